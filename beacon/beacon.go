@@ -24,13 +24,10 @@ import (
 	"errors"
 	"net"
 	"os"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"log"
-
-	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
 )
 
 const (
@@ -43,29 +40,88 @@ var (
 	ipv6Group = "ff02::fa"
 )
 
-// Signal contains the body of the beacon (Transmit) and the source address
+// Signal contains the body of the beacon (Transmit), the source address and
+// the name of the interface it arrived on
 type Signal struct {
-	Addr     string
-	Transmit []byte
+	Addr      string
+	Transmit  []byte
+	Interface string
+}
+
+// PacketConn is a connection beacons go through, on one or more interfaces.
+// By default the beacon opens one on the network for each IP family it works
+// on; SetPacketConn replaces them, e.g. with the connections of a Hub.
+type PacketConn interface {
+	// Interfaces returns the interfaces the connection beacons on.
+	Interfaces() []Interface
+
+	// ReadFrom reads a beacon into buff and tells where it came from and
+	// the name of the interface it arrived on. It returns an error once
+	// the connection is closed.
+	ReadFrom(buff []byte) (n int, src net.IP, iface string, err error)
+
+	// WriteTo sends a beacon out on the named interface.
+	WriteTo(buff []byte, iface string) (n int, err error)
+
+	// Close closes the connection.
+	Close() error
+}
+
+// Interface is an interface a PacketConn beacons on.
+type Interface struct {
+	Name     string
+	Addr     net.IP // Our own address on the interface
+	Loopback bool
+}
+
+// family is the IP family or families the beacon works on
+type family int
+
+const (
+	familyAuto family = iota // IPv4, or IPv6 where there's no IPv4
+	familyIPv4
+	familyIPv6
+	familyDual
+)
+
+// link is an interface we beacon on, in one family
+type link struct {
+	Interface
+	conn PacketConn // Connection the interface is on
+	ipv6 bool       // Whether the link is IPv6
+}
+
+// rank tells how good a default address the link has; we'd rather not use
+// loopback or link-local addresses, and IPv4 is what peers expect by default
+func (l *link) rank() (rank int) {
+	if !l.Loopback {
+		rank += 4
+	}
+	if !l.Addr.IsLinkLocalUnicast() {
+		rank += 2
+	}
+	if !l.ipv6 {
+		rank++
+	}
+	return rank
 }
 
 // Beacon defines main structure of the application
 type Beacon struct {
 	signals    chan interface{}
-	ipv4Conn   *ipv4.PacketConn // UDP incoming connection for sending/receiving beacons
-	ipv6Conn   *ipv6.PacketConn // UDP incoming connection for sending/receiving beacons
-	ipv4       bool             // Whether or not connection is in ipv4 mode
-	port       int              // UDP port number we work on
-	interval   time.Duration    // Beacon broadcast interval
-	noecho     bool             // Ignore own (unique) beacons
-	terminated bool             // API shut us down
-	transmit   []byte           // Beacon transmit data
-	filter     []byte           // Beacon filter data
-	addr       string           // Our own address
-	iface      string
+	conns      []PacketConn  // Connections we work on, once started
+	given      []PacketConn  // Connections set with SetPacketConn
+	family     family        // IP families we work on
+	port       int           // UDP port number we work on
+	interval   time.Duration // Beacon broadcast interval
+	noecho     bool          // Ignore own (unique) beacons
+	terminated bool          // API shut us down
+	transmit   []byte        // Beacon transmit data
+	filter     []byte        // Beacon filter data
+	ifaces     []string      // Interfaces to use, all of them if empty
+	links      []*link       // Interfaces we beacon on
+	done       chan struct{}
 	wg         sync.WaitGroup
-	inAddr     *net.UDPAddr
-	outAddr    *net.UDPAddr
 	sync.Mutex
 }
 
@@ -75,6 +131,7 @@ func New() (b *Beacon) {
 	b = &Beacon{
 		signals:  make(chan interface{}, 50),
 		interval: defaultInterval,
+		done:     make(chan struct{}),
 	}
 
 	return b
@@ -82,170 +139,198 @@ func New() (b *Beacon) {
 
 func (b *Beacon) start() (err error) {
 
-	if b.iface == "" {
-		b.iface = os.Getenv("BEACON_INTERFACE")
-	}
-	if b.iface == "" {
-		b.iface = os.Getenv("ZSYS_INTERFACE")
-	}
-
-	var ifs []net.Interface
-
-	if b.iface == "" {
-		ifs, err = net.Interfaces()
+	conns := b.given
+	if conns == nil {
+		conns, err = b.open()
 		if err != nil {
 			return err
 		}
+	}
 
-	} else {
-		iface, err := net.InterfaceByName(b.iface)
-		if err != nil {
-			return err
+	for _, conn := range conns {
+		for _, iface := range conn.Interfaces() {
+			b.links = append(b.links, &link{Interface: iface, conn: conn, ipv6: iface.Addr.To4() == nil})
+		}
+	}
+	if len(b.links) == 0 {
+		for _, conn := range conns {
+			conn.Close()
 		}
-		ifs = append(ifs, *iface)
+		return errors.New("no interfaces to bind to")
 	}
+	b.conns = conns
 
-	conn, err := net.ListenPacket("udp4", net.JoinHostPort("224.0.0.0", strconv.Itoa(b.port)))
-	if err == nil {
-		b.ipv4Conn = ipv4.NewPacketConn(conn)
-		b.ipv4Conn.SetMulticastLoopback(true)
-		b.ipv4Conn.SetControlMessage(ipv4.FlagSrc, true)
+	for _, conn := range conns {
+		b.wg.Add(1)
+		go b.listen(conn)
 	}
+	b.wg.Add(1)
+	go b.signal()
 
-	if !b.ipv4 {
-		conn, err := net.ListenPacket("udp6", net.JoinHostPort(net.IPv6linklocalallnodes.String(), strconv.Itoa(b.port)))
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		b.ipv6Conn = ipv6.NewPacketConn(conn)
-		b.ipv6Conn.SetMulticastLoopback(true)
-		b.ipv6Conn.SetControlMessage(ipv6.FlagSrc, true)
-	}
+// open opens the connections on the network, one for each family we work
+// on, on the interfaces we've been asked for or on all of them
+func (b *Beacon) open() (conns []PacketConn, err error) {
 
-	broadcast := os.Getenv("BEACON_BROADCAST") != ""
+	names := b.ifaces
+	if len(names) == 0 {
+		names = splitInterfaces(os.Getenv("BEACON_INTERFACE"))
+	}
+	if len(names) == 0 {
+		names = splitInterfaces(os.Getenv("ZSYS_INTERFACE"))
+	}
 
-	for _, iface := range ifs {
-		if b.ipv4Conn != nil {
-			b.inAddr = &net.UDPAddr{
-				IP: ipv4Group,
-			}
-			err := b.ipv4Conn.JoinGroup(&iface, b.inAddr)
-			if err != nil {
-				return err
-			}
+	var ifs []net.Interface
 
-			// Find IP of the interface
-			// TODO(armen): Let user set the ipaddress which here can be verified to be valid
-			addrs, err := iface.Addrs()
-			if err != nil {
-				return err
-			}
+	if len(names) == 0 {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
 
-			if len(addrs) <= 0 {
-				return errors.New("no address to bind to")
+		// Without a choice we use every interface we can beacon on
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&(net.FlagMulticast|net.FlagLoopback) != 0 {
+				ifs = append(ifs, iface)
 			}
-
-			ip, ipnet, err := net.ParseCIDR(addrs[0].String())
+		}
+	} else {
+		for _, name := range names {
+			iface, err := net.InterfaceByName(name)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			b.addr = ip.String()
-
-			switch {
-			case broadcast:
-				bcast := ipnet.IP
-				for i := 0; i < len(ipnet.Mask); i++ {
-					bcast[i] |= ^ipnet.Mask[i]
-				}
-				b.outAddr = &net.UDPAddr{IP: bcast, Port: b.port}
+			ifs = append(ifs, *iface)
+		}
+	}
 
-			case iface.Flags&net.FlagLoopback != 0:
-				b.outAddr = &net.UDPAddr{IP: net.IPv4allsys, Port: b.port}
+	broadcast := os.Getenv("BEACON_BROADCAST") != ""
 
-			default:
-				b.outAddr = &net.UDPAddr{IP: ipv4Group, Port: b.port}
-			}
+	if b.family != familyIPv6 {
+		conn, err := listenUDP(false, b.port, ifs, broadcast)
+		if err == nil {
+			conns = append(conns, conn)
+		} else if b.family == familyIPv4 {
+			return nil, err
+		}
+	}
 
-			break
-		} else if b.ipv6Conn != nil {
-			b.inAddr = &net.UDPAddr{
-				IP: net.ParseIP(ipv6Group),
-			}
-			err := b.ipv6Conn.JoinGroup(&iface, b.inAddr)
-			if err != nil {
-				return err
-			}
+	if b.family == familyIPv6 || b.family == familyDual || len(conns) == 0 {
+		conn, err := listenUDP(true, b.port, ifs, broadcast)
+		if err == nil {
+			conns = append(conns, conn)
+		} else if len(conns) == 0 {
+			// A dual stack beacon makes do with IPv4 alone
+			return nil, err
+		}
+	}
 
-			// Find IP of the interface
-			// TODO(armen): Let user set the ipaddress which here can be verified to be valid
-			addrs, err := iface.Addrs()
-			if err != nil {
-				return err
+	// An interface may only have addresses of one family, e.g. on IPv6
+	// only segments, it's enough for it to work in one. The interfaces
+	// we've been asked for must all work, when we chose them ourselves we
+	// skip the ones we can't use.
+	for _, name := range names {
+		var found bool
+		for _, conn := range conns {
+			for _, iface := range conn.Interfaces() {
+				found = found || iface.Name == name
 			}
-			ip, ipnet, err := net.ParseCIDR(addrs[0].String())
-			if err != nil {
-				return err
+		}
+		if !found {
+			for _, conn := range conns {
+				conn.Close()
 			}
-			b.addr = ip.String()
-
-			switch {
-			case broadcast:
-				bcast := ipnet.IP
-				for i := 0; i < len(ipnet.Mask); i++ {
-					bcast[i] |= ^ipnet.Mask[i]
-				}
-				b.outAddr = &net.UDPAddr{IP: bcast, Port: b.port}
+			return nil, errors.New("can't beacon on " + name)
+		}
+	}
 
-			case iface.Flags&net.FlagLoopback != 0:
-				b.outAddr = &net.UDPAddr{IP: net.IPv6interfacelocalallnodes, Port: b.port}
+	return conns, nil
+}
 
-			default:
-				b.outAddr = &net.UDPAddr{IP: net.ParseIP(ipv6Group), Port: b.port}
-			}
-			break
+// splitInterfaces splits a comma separated list of interface names
+func splitInterfaces(names string) (ifaces []string) {
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ifaces = append(ifaces, name)
 		}
 	}
+	return ifaces
+}
 
-	if b.ipv4Conn == nil && b.ipv6Conn == nil {
-		return errors.New("no interfaces to bind to")
+// Close terminates the beacon.
+func (b *Beacon) Close() {
+	b.Lock()
+	if b.terminated {
+		b.Unlock()
+		return
 	}
+	b.terminated = true
+	b.Unlock()
 
-	go b.listen()
-	go b.signal()
+	// Closing the connections wakes up listen()
+	close(b.done)
+	for _, conn := range b.conns {
+		conn.Close()
+	}
 
-	return nil
+	b.wg.Wait()
+	close(b.signals)
 }
 
-// Close terminates the beacon.
-func (b *Beacon) Close() {
+// Addr returns our own IP address as printable string. When we beacon on
+// several interfaces or in both families, it's the one peers are most
+// likely to reach, e.g. IPv4 rather than IPv6 and no loopback.
+func (b *Beacon) Addr() string {
 	b.Lock()
-	b.terminated = true
+	defer b.Unlock()
 
-	if b.signals != nil {
-		close(b.signals)
+	var best *link
+	for _, l := range b.links {
+		if best == nil || l.rank() > best.rank() {
+			best = l
+		}
 	}
-	b.Unlock()
+	if best == nil {
+		return ""
+	}
+	return best.Addr.String()
+}
 
-	// Send a nil udp data to wake up listen()
-	if b.ipv4Conn != nil {
-		b.ipv4Conn.WriteTo(nil, nil, b.outAddr)
-	} else {
-		b.ipv6Conn.WriteTo(nil, nil, b.outAddr)
+// LocalAddr returns our own IP address as printable string on the interface
+// the signal arrived on, in the family of its source address, or an empty
+// string if we don't beacon there.
+func (b *Beacon) LocalAddr(s *Signal) string {
+	ip := net.ParseIP(s.Addr)
+	if ip == nil {
+		return ""
 	}
 
-	b.wg.Wait()
+	b.Lock()
+	defer b.Unlock()
 
-	if b.ipv4Conn != nil {
-		b.ipv4Conn.Close()
-	} else {
-		b.ipv6Conn.Close()
+	for _, l := range b.links {
+		if l.Name == s.Interface && l.ipv6 == (ip.To4() == nil) {
+			return l.Addr.String()
+		}
 	}
+	return ""
 }
 
-// Addr returns our own IP address as printable string
-func (b *Beacon) Addr() string {
-	return b.addr
+// Interfaces returns the names of the interfaces we beacon on.
+func (b *Beacon) Interfaces() (ifaces []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	seen := make(map[string]bool)
+	for _, l := range b.links {
+		if !seen[l.Name] {
+			seen[l.Name] = true
+			ifaces = append(ifaces, l.Name)
+		}
+	}
+	return ifaces
 }
 
 // Port returns port number
@@ -253,9 +338,41 @@ func (b *Beacon) Port() int {
 	return b.port
 }
 
-// SetInterface sets interface to bind and listen on.
-func (b *Beacon) SetInterface(iface string) *Beacon {
-	b.iface = iface
+// SetPacketConn makes the beacon work on the given connections rather than
+// on the network, e.g. on a Hub to simulate a network in tests. The port,
+// interfaces and families set on the beacon don't apply then.
+func (b *Beacon) SetPacketConn(conns ...PacketConn) *Beacon {
+	b.given = conns
+	return b
+}
+
+// SetInterface sets the interfaces to bind and listen on. Without any, the
+// beacon uses all the interfaces which are up and can multicast.
+func (b *Beacon) SetInterface(ifaces ...string) *Beacon {
+	b.ifaces = nil
+	for _, iface := range ifaces {
+		b.ifaces = append(b.ifaces, splitInterfaces(iface)...)
+	}
+	return b
+}
+
+// SetIPv4Only makes the beacon work on IPv4 alone.
+func (b *Beacon) SetIPv4Only() *Beacon {
+	b.family = familyIPv4
+	return b
+}
+
+// SetIPv6Only makes the beacon work on IPv6 alone.
+func (b *Beacon) SetIPv6Only() *Beacon {
+	b.family = familyIPv6
+	return b
+}
+
+// SetDualStack makes the beacon work on IPv4 and IPv6 at once, on each
+// interface in whichever family it has an address in. By default the beacon
+// works on IPv4, or on IPv6 if there's no IPv4.
+func (b *Beacon) SetDualStack() *Beacon {
+	b.family = familyDual
 	return b
 }
 
@@ -283,6 +400,10 @@ func (b *Beacon) Publish(transmit []byte) error {
 	defer b.Unlock()
 	b.transmit = transmit
 
+	// Publishing again only changes the transmit data
+	if b.conns != nil {
+		return nil
+	}
 	err := b.start()
 
 	return err
@@ -297,6 +418,27 @@ func (b *Beacon) Silence() *Beacon {
 	return b
 }
 
+// Send sends the given beacon once on every interface right away, e.g. a
+// last one telling peers we're going away before Close. What's published
+// doesn't change.
+func (b *Beacon) Send(transmit []byte) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.conns == nil {
+		return errors.New("beacon isn't published")
+	}
+
+	var err error
+	for _, l := range b.links {
+		if _, e := l.conn.WriteTo(transmit, l.Name); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
 // Subscribe starts listening to other peers; zero-sized filter means get everything.
 func (b *Beacon) Subscribe(filter []byte) *Beacon {
 	b.filter = filter
@@ -314,16 +456,10 @@ func (b *Beacon) Signals() chan interface{} {
 	return b.signals
 }
 
-func (b *Beacon) listen() {
-	b.wg.Add(1)
+// listen receives the beacons of a connection
+func (b *Beacon) listen(conn PacketConn) {
 	defer b.wg.Done()
 
-	var (
-		n    int
-		addr net.IP
-		err  error
-	)
-
 	for {
 		buff := make([]byte, beaconMax)
 
@@ -334,30 +470,21 @@ func (b *Beacon) listen() {
 		}
 		b.Unlock()
 
-		if b.ipv4Conn != nil {
-			var cm *ipv4.ControlMessage
-			n, cm, _, err = b.ipv4Conn.ReadFrom(buff)
-			if err != nil || n > beaconMax || n == 0 || cm == nil {
-				continue
-			}
-			addr = cm.Src
-		} else {
-			var cm *ipv6.ControlMessage
-			n, cm, _, err = b.ipv6Conn.ReadFrom(buff)
-			if err != nil || n > beaconMax || n == 0 || cm == nil {
-				continue
-			}
-			addr = cm.Src
+		n, addr, iface, err := conn.ReadFrom(buff)
+		if err != nil || n > beaconMax || n == 0 {
+			continue
 		}
 
+		b.Lock()
 		send := bytes.HasPrefix(buff[:n], b.filter)
 		if send && b.noecho {
 			send = !bytes.Equal(buff[:n], b.transmit)
 		}
+		b.Unlock()
 
-		if send && !b.terminated {
+		if send {
 			select {
-			case b.signals <- &Signal{addr.String(), buff[:n]}:
+			case b.signals <- &Signal{addr.String(), buff[:n], iface}:
 			default:
 			}
 		}
@@ -365,43 +492,34 @@ func (b *Beacon) listen() {
 }
 
 func (b *Beacon) signal() {
-	b.wg.Add(1)
 	defer b.wg.Done()
 
-	var ticker <-chan time.Time
-
-	if b.interval == 0 {
-		ticker = time.After(defaultInterval)
-	} else {
-		ticker = time.After(b.interval)
-	}
-
 	for {
+		b.Lock()
+		interval := b.interval
+		b.Unlock()
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+
 		select {
-		case <-ticker:
-			b.Lock()
-			if b.terminated {
-				b.Unlock()
-				return
-			}
-			if b.transmit != nil {
-				// Signal other beacons
-				var err error
-				if b.ipv4Conn != nil {
-					_, err = b.ipv4Conn.WriteTo(b.transmit, nil, b.outAddr)
-				} else {
-					_, err = b.ipv6Conn.WriteTo(b.transmit, nil, b.outAddr)
-				}
+		case <-time.After(interval):
+		case <-b.done:
+			return
+		}
 
+		b.Lock()
+		if b.transmit != nil {
+			// Signal other beacons on each of our interfaces
+			for _, l := range b.links {
+				_, err := l.conn.WriteTo(b.transmit, l.Name)
 				if err != nil {
-					// Avoid panic when doing 
+					// Avoid panic when doing
 					//    root> systemctl restart network
-					log.Printf ("Ticker failed: %s\n", err)
+					log.Printf("Ticker failed on %s: %s\n", l.Name, err)
 				}
 			}
-			b.Unlock()
-
-			ticker = time.After(b.interval)
 		}
+		b.Unlock()
 	}
 }
@@ -3,6 +3,8 @@ package beacon
 import (
 	"bytes"
 	"math/rand"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -24,9 +26,15 @@ func TestBeacon(t *testing.T) {
 		if !bytes.Equal(transmit, signal.Transmit) {
 			t.Fatalf("expected % X, got % X", transmit, signal.Transmit)
 		}
+		if signal.Interface == "" || b.LocalAddr(signal) == "" {
+			t.Fatalf("expected the signal to arrive on one of %v, got %q", b.Interfaces(), signal.Interface)
+		}
 	}
 
+	// The beacon went out on each interface, the copies from the others
+	// may still be on their way
 	b.Silence()
+	drainSignals(b, 50*time.Millisecond)
 
 	select {
 	case <-time.After(300 * time.Millisecond):
@@ -112,3 +120,231 @@ func random(min, max int) int {
 	rand.Seed(time.Now().Unix())
 	return rand.Intn(max-min) + min
 }
+
+func TestSplitInterfaces(t *testing.T) {
+	for names, want := range map[string][]string{
+		"":                nil,
+		"eth0":            {"eth0"},
+		"eth0, eth1,,lo ": {"eth0", "eth1", "lo"},
+	} {
+		if got := splitInterfaces(names); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v for %q, got %v", want, names, got)
+		}
+	}
+}
+
+func TestAddr(t *testing.T) {
+	l := func(name, addr string, loopback bool) *link {
+		ip := net.ParseIP(addr)
+		return &link{Interface: Interface{Name: name, Addr: ip, Loopback: loopback}, ipv6: ip.To4() == nil}
+	}
+
+	b := New()
+	b.links = []*link{
+		l("lo", "127.0.0.1", true),
+		l("eth0", "fe80::1", false),
+		l("eth0", "2001:db8::1", false),
+	}
+	if addr := b.Addr(); addr != "2001:db8::1" {
+		t.Errorf("expected the global IPv6 address, got %s", addr)
+	}
+
+	b.links = append(b.links, l("eth0", "192.168.1.10", false))
+	if addr := b.Addr(); addr != "192.168.1.10" {
+		t.Errorf("expected the IPv4 address, got %s", addr)
+	}
+
+	s := &Signal{Addr: "fe80::2", Interface: "eth0"}
+	if addr := b.LocalAddr(s); addr != "fe80::1" {
+		t.Errorf("expected our first IPv6 address on eth0, got %s", addr)
+	}
+	s = &Signal{Addr: "10.0.0.1", Interface: "lo"}
+	if addr := b.LocalAddr(s); addr != "127.0.0.1" {
+		t.Errorf("expected our IPv4 address on lo, got %s", addr)
+	}
+	if ifaces := b.Interfaces(); !reflect.DeepEqual(ifaces, []string{"lo", "eth0"}) {
+		t.Errorf("expected lo and eth0, got %v", ifaces)
+	}
+}
+
+// expectSignals collects the signals a beacon receives for a while
+func expectSignals(b *Beacon, wait time.Duration) map[string]int {
+	got := make(map[string]int)
+	timeout := time.After(wait)
+	for {
+		select {
+		case s := <-b.Signals():
+			got[string(s.(*Signal).Transmit)]++
+		case <-timeout:
+			return got
+		}
+	}
+}
+
+// drainSignals reads the signals until none has come for the given time
+func drainSignals(b *Beacon, quiet time.Duration) (signals []*Signal) {
+	for {
+		select {
+		case s := <-b.Signals():
+			signals = append(signals, s.(*Signal))
+		case <-time.After(quiet):
+			return signals
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(1)
+	nodes := make([]*Beacon, 4)
+	conns := make([]*HubConn, len(nodes))
+	for i := range nodes {
+		conns[i] = hub.Join(net.IPv4(10, 0, 0, byte(i+1)))
+		nodes[i] = New().SetPacketConn(conns[i]).SetInterval(10 * time.Millisecond).NoEcho()
+		err := nodes[i].Publish([]byte{'N', byte('0' + i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer nodes[i].Close()
+	}
+
+	// Every node hears the others, on the hub's interface
+	s := (<-nodes[0].Signals()).(*Signal)
+	if s.Interface != "hub" || nodes[0].LocalAddr(s) != "10.0.0.1" || nodes[0].Addr() != "10.0.0.1" {
+		t.Errorf("expected a signal on the hub to 10.0.0.1, got %+v", s)
+	}
+	got := expectSignals(nodes[0], 200*time.Millisecond)
+	for _, want := range []string{"N1", "N2", "N3"} {
+		if got[want] == 0 {
+			t.Errorf("expected node0 to hear %s, got %v", want, got)
+		}
+	}
+	if got["N0"] != 0 {
+		t.Errorf("expected node0 not to hear its own beacons, got %v", got)
+	}
+
+	// Partitioned nodes only hear the ones on their side
+	hub.Partition(conns[:2], conns[2:])
+	expectSignals(nodes[0], 50*time.Millisecond)
+	got = expectSignals(nodes[0], 200*time.Millisecond)
+	if got["N1"] == 0 || got["N2"] != 0 || got["N3"] != 0 {
+		t.Errorf("expected node0 to hear node1 alone, got %v", got)
+	}
+
+	// Some beacons get lost
+	hub.Heal()
+	hub.SetLoss(0.5)
+	expectSignals(nodes[0], 50*time.Millisecond)
+	got = expectSignals(nodes[0], 500*time.Millisecond)
+	for _, want := range []string{"N1", "N2", "N3"} {
+		if got[want] == 0 || got[want] > 40 {
+			t.Errorf("expected node0 to hear about half of %s, got %v", want, got)
+		}
+	}
+
+	// And all of them with a total loss
+	hub.SetLoss(1)
+	expectSignals(nodes[0], 50*time.Millisecond)
+	if got = expectSignals(nodes[0], 200*time.Millisecond); len(got) != 0 {
+		t.Errorf("expected node0 to hear nothing, got %v", got)
+	}
+
+	// A closed connection hears nothing more
+	nodes[3].Close()
+	if _, _, _, err := conns[3].ReadFrom(make([]byte, beaconMax)); err == nil {
+		t.Error("expected the closed connection to fail")
+	}
+}
+
+func TestHubLoss(t *testing.T) {
+	// The same seed loses the same beacons
+	lost := func() (lost []int) {
+		hub := NewHub(42).SetLoss(0.3)
+		a, b := hub.Join(net.IPv4(10, 0, 0, 1)), hub.Join(net.IPv4(10, 0, 0, 2))
+		defer a.Close()
+		defer b.Close()
+
+		buff := make([]byte, beaconMax)
+		for i := 0; i < 20; i++ {
+			a.WriteTo([]byte{byte(i)}, "hub")
+			a.ReadFrom(buff) // Our own beacon
+			select {
+			case p := <-b.packets:
+				if int(p.data[0]) != i {
+					t.Fatalf("expected beacon %d, got %d", i, p.data[0])
+				}
+			default:
+				lost = append(lost, i)
+			}
+		}
+		return lost
+	}
+
+	first := lost()
+	if len(first) == 0 || len(first) == 20 {
+		t.Errorf("expected some beacons to be lost, got %v", first)
+	}
+	if second := lost(); !reflect.DeepEqual(first, second) {
+		t.Errorf("expected %v to be lost again, got %v", first, second)
+	}
+}
+
+func TestSend(t *testing.T) {
+	hub := NewHub(1)
+	a := New().SetPacketConn(hub.Join(net.IPv4(10, 0, 0, 1))).SetInterval(time.Hour)
+	b := New().SetPacketConn(hub.Join(net.IPv4(10, 0, 0, 2))).SetInterval(time.Hour)
+	defer b.Close()
+
+	if err := a.Send([]byte("BYE")); err == nil {
+		t.Error("expected an unpublished beacon not to send")
+	}
+	for _, node := range []*Beacon{a, b} {
+		err := node.Publish([]byte("HELLO"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The beacon goes out right away, not on the next interval, and
+	// before the connection is closed
+	err := a.Send([]byte("BYE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if got := expectSignals(b, 100*time.Millisecond); got["BYE"] != 1 || got["HELLO"] != 0 {
+		t.Errorf("expected BYE alone, got %v", got)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	// Two networks; a and b are on both, c on the first one only
+	hubs := []*Hub{NewHub(1), NewHub(2)}
+	a := New().SetPacketConn(hubs[0].Join(net.IPv4(10, 0, 0, 1)), hubs[1].Join(net.IPv4(10, 0, 1, 1))).SetInterval(20 * time.Millisecond)
+	b := New().SetPacketConn(hubs[0].Join(net.IPv4(10, 0, 0, 2)), hubs[1].Join(net.IPv4(10, 0, 1, 2))).SetInterval(time.Hour)
+	c := New().SetPacketConn(hubs[0].Join(net.IPv4(10, 0, 0, 3))).SetInterval(time.Hour)
+	for _, node := range []*Beacon{a, b, c} {
+		err := node.Publish([]byte{'N'})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer node.Close()
+	}
+
+	// Each beacon goes out once on every link; Silence waits for the
+	// round being sent, so the rounds heard are whole
+	first := (<-b.Signals()).(*Signal)
+	time.Sleep(100 * time.Millisecond)
+	a.Silence()
+	got := map[string]int{first.Addr: 1}
+	for _, s := range drainSignals(b, 50*time.Millisecond) {
+		got[s.Addr]++
+	}
+	if len(got) != 2 || got["10.0.0.1"] < 2 || got["10.0.0.1"] != got["10.0.1.1"] {
+		t.Errorf("expected b to hear a as often on both links, got %v", got)
+	}
+	for _, s := range drainSignals(c, 10*time.Millisecond) {
+		if s.Addr != "10.0.0.1" {
+			t.Errorf("expected c to hear a on the first link alone, got %+v", s)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package beacon
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// hubInterface is the name of the interface the connections of a hub are on
+const hubInterface = "hub"
+
+var errHubClosed = errors.New("hub connection is closed")
+
+// Hub is an in-memory network for beacons, to simulate many nodes, packet
+// loss and partitions in tests without touching the real network. Each
+// connection made with Join hears the beacons of the others, as well as its
+// own as multicast loops them back. Which beacons get lost is drawn from a
+// source seeded for each connection, so a test losing beacons loses the same
+// ones every time it runs.
+type Hub struct {
+	seed  int64
+	loss  float64
+	conns []*HubConn
+	sync.Mutex
+}
+
+// HubConn is a connection to a Hub, see SetPacketConn.
+type HubConn struct {
+	hub       *Hub
+	addr      net.IP
+	partition int        // Connections only hear the ones in the same partition
+	rand      *rand.Rand // Draws the beacons this connection loses
+	packets   chan hubPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// hubPacket is a beacon on its way through the hub
+type hubPacket struct {
+	src  net.IP
+	data []byte
+}
+
+// NewHub creates a hub whose losses are drawn from the given seed.
+func NewHub(seed int64) *Hub {
+	return &Hub{seed: seed}
+}
+
+// Join connects a node with the given address to the hub.
+func (h *Hub) Join(addr net.IP) *HubConn {
+	h.Lock()
+	defer h.Unlock()
+
+	c := &HubConn{
+		hub:     h,
+		addr:    addr,
+		rand:    rand.New(rand.NewSource(h.seed + int64(len(h.conns)))),
+		packets: make(chan hubPacket, 50),
+		closed:  make(chan struct{}),
+	}
+	h.conns = append(h.conns, c)
+
+	return c
+}
+
+// SetLoss sets the share of beacons lost on their way to each of the other
+// connections, from 0 for none to 1 for all of them.
+func (h *Hub) SetLoss(loss float64) *Hub {
+	h.Lock()
+	defer h.Unlock()
+
+	h.loss = loss
+	return h
+}
+
+// Partition splits the hub, the connections of each group only hear each
+// other from now on. The connections in none of the groups still hear each
+// other.
+func (h *Hub) Partition(groups ...[]*HubConn) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, c := range h.conns {
+		c.partition = 0
+	}
+	for i, group := range groups {
+		for _, c := range group {
+			c.partition = i + 1
+		}
+	}
+}
+
+// Heal undoes the partitions, all the connections hear each other again.
+func (h *Hub) Heal() {
+	h.Partition()
+}
+
+// send delivers a beacon to the connections in the same partition, unless
+// it's lost on its way; a connection whose queue is full drops it as well
+func (h *Hub) send(from *HubConn, data []byte) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, c := range h.conns {
+		if c != from && (c.partition != from.partition || from.rand.Float64() < h.loss) {
+			continue
+		}
+		select {
+		case c.packets <- hubPacket{src: from.addr, data: append([]byte{}, data...)}:
+		default:
+		}
+	}
+}
+
+// Interfaces returns the single interface of the hub.
+func (c *HubConn) Interfaces() []Interface {
+	return []Interface{{Name: hubInterface, Addr: c.addr}}
+}
+
+// ReadFrom reads a beacon sent through the hub.
+func (c *HubConn) ReadFrom(buff []byte) (n int, src net.IP, iface string, err error) {
+	select {
+	case p := <-c.packets:
+		return copy(buff, p.data), p.src, hubInterface, nil
+	case <-c.closed:
+		return 0, nil, "", errHubClosed
+	}
+}
+
+// WriteTo sends a beacon through the hub.
+func (c *HubConn) WriteTo(buff []byte, iface string) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, errHubClosed
+	default:
+	}
+	if iface != hubInterface {
+		return 0, errors.New("not beaconing on " + iface)
+	}
+
+	c.hub.send(c, buff)
+	return len(buff), nil
+}
+
+// Close closes the connection, the others don't hear it anymore.
+func (c *HubConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.hub.Lock()
+		defer c.hub.Unlock()
+		for i, other := range c.hub.conns {
+			if other == c {
+				c.hub.conns = append(c.hub.conns[:i], c.hub.conns[i+1:]...)
+				break
+			}
+		}
+	})
+
+	return nil
+}
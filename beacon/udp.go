@@ -0,0 +1,211 @@
+package beacon
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// udpConn is a PacketConn on the real network, in one IP family. It joins
+// the multicast group on each interface and sends on each of them.
+type udpConn struct {
+	ipv4Conn *ipv4.PacketConn // UDP connection in IPv4, or
+	ipv6Conn *ipv6.PacketConn // UDP connection in IPv6
+	ifaces   []Interface      // Interfaces we've joined the group on
+	names    map[int]string   // Names of the interfaces by index
+	outs     map[string]udpOut
+}
+
+// udpOut is where the beacons go out to on an interface
+type udpOut struct {
+	index int
+	addr  *net.UDPAddr
+}
+
+// listenUDP opens a connection in the family and joins the group on those of
+// the interfaces which have an address in the family
+func listenUDP(v6 bool, port int, ifs []net.Interface, broadcast bool) (*udpConn, error) {
+	c := &udpConn{
+		names: make(map[int]string),
+		outs:  make(map[string]udpOut),
+	}
+
+	if !v6 {
+		conn, err := net.ListenPacket("udp4", net.JoinHostPort("224.0.0.0", strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		c.ipv4Conn = ipv4.NewPacketConn(conn)
+		c.ipv4Conn.SetMulticastLoopback(true)
+		c.ipv4Conn.SetControlMessage(ipv4.FlagSrc|ipv4.FlagInterface, true)
+	} else {
+		conn, err := net.ListenPacket("udp6", net.JoinHostPort("::", strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		c.ipv6Conn = ipv6.NewPacketConn(conn)
+		c.ipv6Conn.SetMulticastLoopback(true)
+		c.ipv6Conn.SetControlMessage(ipv6.FlagSrc|ipv6.FlagInterface, true)
+	}
+
+	var err error
+	for _, iface := range ifs {
+		if e := c.join(iface, port, broadcast); e != nil {
+			err = e
+		}
+	}
+	if len(c.ifaces) == 0 {
+		c.Close()
+		if err == nil {
+			err = errors.New("no interfaces to bind to")
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// join joins the multicast group on the interface and works out our address
+// on it and where our beacons go
+func (c *udpConn) join(iface net.Interface, port int, broadcast bool) error {
+	// Find IP of the interface, a link-local address is the last resort
+	// TODO(armen): Let user set the ipaddress which here can be verified to be valid
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	v6 := c.ipv6Conn != nil
+	var (
+		ip    net.IP
+		ipnet *net.IPNet
+	)
+	for _, a := range addrs {
+		aip, anet, err := net.ParseCIDR(a.String())
+		if err != nil || (aip.To4() == nil) != v6 {
+			continue
+		}
+		if ip == nil || (ip.IsLinkLocalUnicast() && !aip.IsLinkLocalUnicast()) {
+			ip, ipnet = aip, anet
+		}
+	}
+	if ip == nil {
+		return errors.New("no address to bind to on " + iface.Name)
+	}
+
+	var out *net.UDPAddr
+	if !v6 {
+		err = c.ipv4Conn.JoinGroup(&iface, &net.UDPAddr{IP: ipv4Group})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case broadcast:
+			bcast := ipnet.IP
+			for i := 0; i < len(ipnet.Mask); i++ {
+				bcast[i] |= ^ipnet.Mask[i]
+			}
+			out = &net.UDPAddr{IP: bcast, Port: port}
+
+		case iface.Flags&net.FlagLoopback != 0:
+			out = &net.UDPAddr{IP: net.IPv4allsys, Port: port}
+
+		default:
+			out = &net.UDPAddr{IP: ipv4Group, Port: port}
+		}
+	} else {
+		err = c.ipv6Conn.JoinGroup(&iface, &net.UDPAddr{IP: net.ParseIP(ipv6Group)})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case broadcast:
+			bcast := ipnet.IP
+			for i := 0; i < len(ipnet.Mask); i++ {
+				bcast[i] |= ^ipnet.Mask[i]
+			}
+			out = &net.UDPAddr{IP: bcast, Port: port}
+
+		case iface.Flags&net.FlagLoopback != 0:
+			out = &net.UDPAddr{IP: net.IPv6interfacelocalallnodes, Port: port}
+
+		default:
+			out = &net.UDPAddr{IP: net.ParseIP(ipv6Group), Port: port}
+		}
+	}
+
+	c.ifaces = append(c.ifaces, Interface{
+		Name:     iface.Name,
+		Addr:     ip,
+		Loopback: iface.Flags&net.FlagLoopback != 0,
+	})
+	c.names[iface.Index] = iface.Name
+	c.outs[iface.Name] = udpOut{index: iface.Index, addr: out}
+
+	return nil
+}
+
+// Interfaces returns the interfaces we've joined the group on.
+func (c *udpConn) Interfaces() []Interface {
+	return c.ifaces
+}
+
+// ReadFrom reads a beacon. The group may have been joined on other
+// interfaces by other sockets, what arrives on those is skipped.
+func (c *udpConn) ReadFrom(buff []byte) (n int, src net.IP, iface string, err error) {
+	for {
+		var index int
+		if c.ipv4Conn != nil {
+			var cm *ipv4.ControlMessage
+			n, cm, _, err = c.ipv4Conn.ReadFrom(buff)
+			if err != nil {
+				return 0, nil, "", err
+			}
+			if cm == nil {
+				continue
+			}
+			src, index = cm.Src, cm.IfIndex
+		} else {
+			var cm *ipv6.ControlMessage
+			n, cm, _, err = c.ipv6Conn.ReadFrom(buff)
+			if err != nil {
+				return 0, nil, "", err
+			}
+			if cm == nil {
+				continue
+			}
+			src, index = cm.Src, cm.IfIndex
+		}
+
+		iface, ok := c.names[index]
+		if index != 0 && !ok {
+			continue
+		}
+		return n, src, iface, nil
+	}
+}
+
+// WriteTo sends a beacon out on the interface.
+func (c *udpConn) WriteTo(buff []byte, iface string) (int, error) {
+	out, ok := c.outs[iface]
+	if !ok {
+		return 0, errors.New("not beaconing on " + iface)
+	}
+	if c.ipv4Conn != nil {
+		return c.ipv4Conn.WriteTo(buff, &ipv4.ControlMessage{IfIndex: out.index}, out.addr)
+	}
+	return c.ipv6Conn.WriteTo(buff, &ipv6.ControlMessage{IfIndex: out.index}, out.addr)
+}
+
+// Close closes the connection.
+func (c *udpConn) Close() error {
+	if c.ipv4Conn != nil {
+		return c.ipv4Conn.Close()
+	}
+	return c.ipv6Conn.Close()
+}
@@ -0,0 +1,73 @@
+package gyre
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Bytes of the HMAC-SHA256 authenticated beacons carry
+	beaconMACLen = 16
+
+	// How far the timestamp of an authenticated beacon may be from our
+	// clock, the clocks of the nodes must agree this well
+	beaconFreshness = 30 * time.Second
+)
+
+// beaconMAC returns the truncated HMAC of the signed part of a beacon
+func beaconMAC(secret, signed []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signed)
+	return mac.Sum(nil)[:beaconMACLen]
+}
+
+// signBeacon appends the timestamp and the HMAC to a version 2 beacon
+func signBeacon(buffer *bytes.Buffer, secret []byte, at time.Time) {
+	binary.Write(buffer, binary.BigEndian, uint64(at.UnixNano()/int64(time.Millisecond)))
+	buffer.Write(beaconMAC(secret, buffer.Bytes()))
+}
+
+// authenticate tells whether a beacon is signed with our secret and fresh,
+// counting the ones it rejects. A beacon is replayed if its timestamp is too
+// far from our clock or older than the last one of the same peer; the same
+// beacon may come again over other interfaces or families.
+func (d *beaconDiscovery) authenticate(b *aBeacon) bool {
+	if b.Version != beaconV2 || b.MAC == nil || !hmac.Equal(b.MAC, beaconMAC(d.secret, b.signed)) {
+		atomic.AddUint64(&d.unauthenticated, 1)
+		return false
+	}
+
+	now := time.Now()
+	uuid := string(b.UUID)
+	age := now.Sub(time.Unix(0, int64(b.Timestamp)*int64(time.Millisecond)))
+	if age > beaconFreshness || age < -beaconFreshness || b.Timestamp < d.stamps[uuid] {
+		atomic.AddUint64(&d.replayed, 1)
+		return false
+	}
+	d.stamps[uuid] = b.Timestamp
+
+	// Forget the peers whose beacons would be too old by now anyway
+	if now.Sub(d.pruned) > beaconFreshness {
+		oldest := uint64(now.Add(-beaconFreshness).UnixNano() / int64(time.Millisecond))
+		for uuid, stamp := range d.stamps {
+			if stamp < oldest {
+				delete(d.stamps, uuid)
+			}
+		}
+		d.pruned = now
+	}
+
+	return true
+}
+
+// rejections returns the counts of the beacons we've rejected
+func (d *beaconDiscovery) rejections() BeaconRejections {
+	return BeaconRejections{
+		Unauthenticated: atomic.LoadUint64(&d.unauthenticated),
+		Replayed:        atomic.LoadUint64(&d.replayed),
+	}
+}
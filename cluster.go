@@ -0,0 +1,94 @@
+package gyre
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Header telling the cluster the node belongs to, see SetCluster
+	headerCluster = "X-CLUSTER"
+
+	// Beacons tell the length of the cluster name in a byte
+	maxClusterLen = 255
+
+	// How long a peer from another cluster is ignored once its HELLO has
+	// been rejected; the discovery backends which can't tell the cluster
+	// would make us connect to it on each sighting otherwise
+	clusterRejected = 1 * time.Minute
+)
+
+// setCluster sets the cluster the node belongs to, an empty name takes it
+// out of any cluster
+func (n *node) setCluster(name string) error {
+	if n.started != nil {
+		return fmt.Errorf("%w: cluster must be set before the node is started", ErrInvalidConfig)
+	}
+	if len(name) > maxClusterLen {
+		return fmt.Errorf("%w: cluster name is longer than %d bytes", ErrInvalidConfig, maxClusterLen)
+	}
+
+	n.cluster = name
+	if name == "" {
+		delete(n.headers, headerCluster)
+	} else {
+		n.headers[headerCluster] = name
+	}
+
+	return nil
+}
+
+// rejectPeer ignores a peer from another cluster for a while
+func (n *node) rejectPeer(identity string) {
+	n.rejected[identity] = time.Now().Add(clusterRejected)
+}
+
+// isRejected tells whether a peer is ignored for being from another cluster
+func (n *node) isRejected(identity string) bool {
+	until, ok := n.rejected[identity]
+	if ok && time.Now().After(until) {
+		delete(n.rejected, identity)
+		return false
+	}
+	return ok
+}
+
+// checkRejected forgets the rejected peers which haven't been sighted since
+// their rejection expired
+func (n *node) checkRejected() {
+	now := time.Now()
+	for identity, until := range n.rejected {
+		if now.After(until) {
+			delete(n.rejected, identity)
+		}
+	}
+}
+
+// clusterFilter returns the prefix of the beacons of the cluster; nodes which
+// aren't in any cluster take beacons of all versions and check the cluster
+// once they're parsed
+func clusterFilter(cluster string) []byte {
+	if cluster == "" {
+		return []byte("ZRE")
+	}
+	return append([]byte{'Z', 'R', 'E', beaconV2, byte(len(cluster))}, cluster...)
+}
+
+// gossipKey returns the key we gossip our endpoint under, the UUID prefixed
+// by the cluster if any
+func gossipKey(cluster, uuid string) string {
+	if cluster == "" {
+		return uuid
+	}
+	return cluster + "/" + uuid
+}
+
+// parseGossipKey splits a gossiped key into the cluster and the UUID
+func parseGossipKey(key string) (cluster, uuid string) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
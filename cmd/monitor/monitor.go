@@ -100,6 +100,15 @@ func ping() {
 
 			case gyre.EventShout:
 				log.Printf("[%s] received a SHOUT targeted to %s group from %q\n", node.Name(), e.Group(), e.Name())
+
+			case gyre.EventEvasive:
+				log.Printf("[%s] peer %q is being evasive\n", node.Name(), e.Name())
+
+			case gyre.EventSilent:
+				log.Printf("[%s] peer %q is silent\n", node.Name(), e.Name())
+
+			case gyre.EventLeader:
+				log.Printf("[%s] peer %q leads %s\n", node.Name(), e.Name(), e.Group())
 			}
 		case <-c:
 			return
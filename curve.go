@@ -0,0 +1,142 @@
+package gyre
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+const (
+	// Header advertising the node's CURVE public key, as Zyre does
+	headerPublicKey = "X-PUBLICKEY"
+
+	// Well-known endpoint of the ZeroMQ authentication protocol (ZAP)
+	zapEndpoint = "inproc://zeromq.zap.01"
+)
+
+// Authenticator decides whether a peer presenting the given CURVE public key
+// (Z85 encoded) may connect to the node. It's called from the ZAP handler, not
+// from the node's goroutine, so it must be safe for concurrent use.
+type Authenticator func(publicKey string) bool
+
+// AllowList returns an Authenticator which lets in the given keys only.
+func AllowList(keys ...string) Authenticator {
+	allowed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		allowed[key] = true
+	}
+	return func(publicKey string) bool {
+		return allowed[publicKey]
+	}
+}
+
+// validKey checks that key is a Z85 encoded CURVE key.
+func validKey(key string) error {
+	if len(key) != 40 || len(zmq.Z85decode(key)) != 32 {
+		return fmt.Errorf("%w: invalid CURVE key %q", ErrInvalidConfig, key)
+	}
+
+	return nil
+}
+
+// There can be only one ZAP handler per process. It's started by the first
+// node which sets an Authenticator and serves all nodes, each using its own
+// ZAP domain. Connections on other domains are let in.
+var zap struct {
+	sync.Mutex
+	handler *zmq.Socket
+	domains map[string]Authenticator
+}
+
+// zapRegister hands authentication of the given ZAP domain to auth.
+func zapRegister(domain string, auth Authenticator) error {
+	zap.Lock()
+	defer zap.Unlock()
+
+	if zap.handler == nil {
+		handler, err := zmq.NewSocket(zmq.REP)
+		if err != nil {
+			return err
+		}
+		err = handler.Bind(zapEndpoint)
+		if err != nil {
+			handler.Close()
+			return fmt.Errorf("can't start ZAP handler, is another one running? %w", err)
+		}
+		zap.handler = handler
+		if zap.domains == nil {
+			zap.domains = make(map[string]Authenticator)
+		}
+		go zapServe(handler)
+	}
+	zap.domains[domain] = auth
+
+	return nil
+}
+
+// zapUnregister stops authenticating the given ZAP domain.
+func zapUnregister(domain string) {
+	zap.Lock()
+	defer zap.Unlock()
+
+	delete(zap.domains, domain)
+}
+
+// zapServe answers ZAP requests for the lifetime of the process. Should it
+// fail anyway, the next node setting an Authenticator starts a new handler.
+func zapServe(handler *zmq.Socket) {
+	defer func() {
+		zap.Lock()
+		if zap.handler == handler {
+			zap.handler = nil
+		}
+		zap.Unlock()
+		handler.Close()
+	}()
+
+	for {
+		request, err := handler.RecvMessage(0)
+		if err != nil {
+			log.Printf("ZAP handler: %s", err)
+			return
+		}
+
+		// Request: version, request id, domain, address, identity,
+		// mechanism and credentials
+		// A REP socket can't receive again before it has replied
+		if len(request) < 6 {
+			log.Printf("ZAP handler: malformed request %q", request)
+			version, requestID := "1.0", ""
+			if len(request) >= 2 {
+				version, requestID = request[0], request[1]
+			}
+			handler.SendMessage(version, requestID, "500", "Malformed request", "", "")
+			continue
+		}
+		version, requestID, domain, mechanism := request[0], request[1], request[2], request[5]
+
+		zap.Lock()
+		auth, ok := zap.domains[domain]
+		zap.Unlock()
+
+		// The client's key becomes the User-Id of the connection, so
+		// the node can tell which key a peer has authenticated with
+		var key string
+		if mechanism == "CURVE" && len(request) > 6 {
+			key = zmq.Z85encode(request[6])
+		}
+
+		allowed := true
+		if ok {
+			allowed = key != "" && auth(key)
+		}
+
+		if allowed {
+			handler.SendMessage(version, requestID, "200", "OK", key, "")
+		} else {
+			handler.SendMessage(version, requestID, "400", "Not authorized", "", "")
+		}
+	}
+}
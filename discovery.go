@@ -0,0 +1,724 @@
+package gyre
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zeromq/gyre/beacon"
+	"github.com/zeromq/gyre/zgossip"
+)
+
+// Discovery finds the peers of a node, e.g. UDP beacons or gossip. A node runs
+// the built-in backends it's configured for alongside the ones set with
+// SetDiscovery, and connects to every peer any of them has sighted.
+//
+// Start, Announce and Stop are called from the node's goroutine, Sightings is
+// read from a goroutine of its own.
+type Discovery interface {
+	// Start starts discovering peers, it's called when the node starts.
+	Start() error
+
+	// Stop stops discovery, telling the peers the node is going away if
+	// the backend can. It's called when the node stops.
+	Stop() error
+
+	// Announce makes the node known to its peers, it's called once the
+	// node has started, with its UUID and the endpoint of its inbox.
+	Announce(uuid, endpoint string) error
+
+	// Sightings returns the channel of peers discovered by the backend.
+	// It may be closed once the backend has stopped.
+	Sightings() <-chan Sighting
+}
+
+// Sighting is a peer discovered by a Discovery backend. An empty Endpoint
+// means the peer is going away.
+type Sighting struct {
+	UUID     string
+	Endpoint string
+
+	// LocalAddr is our own IP address on the network the peer was
+	// sighted on, if the backend knows it. A node listening on all
+	// interfaces greets the peer with it rather than its own endpoint.
+	LocalAddr string
+}
+
+// Beacon frame has this format in version 1:
+//
+// Z R E       3 bytes
+// Version     1 byte, %x01
+// UUID        16 bytes
+// Port        2 bytes in network order
+//
+// Peers take our address from the source of the beacon. A zero port means
+// we're going away. Version 2 tells the endpoint we're advertising instead,
+// and the cluster the node belongs to, if any:
+//
+// Z R E       3 bytes
+// Version     1 byte, %x02
+// Cluster     1 byte length followed by the string
+// UUID        16 bytes
+// Endpoint    1 byte length followed by the string
+//
+// An empty endpoint means we're going away. In shared secret mode, the
+// beacon goes on with:
+//
+// Timestamp   8 bytes, milliseconds since the epoch in network order
+// HMAC        16 bytes, HMAC-SHA256 of all the above, truncated
+//
+// Anything after that is left for later versions to extend and is ignored.
+type aBeacon struct {
+	Protocol  [3]byte
+	Version   byte
+	Cluster   string
+	UUID      []byte
+	Port      uint16
+	Endpoint  string
+	Timestamp uint64
+	MAC       []byte
+	signed    []byte // The part of the beacon the HMAC is of
+}
+
+const (
+	beaconV1 = 0x1
+	beaconV2 = 0x2
+
+	// Beacons don't get any bigger
+	beaconMax = 255
+
+	// How long our gossiped tuple lives unless it's published again
+	gossipTTL = 30 * time.Second
+
+	// How many beacon intervals a peer heard in one IP family must be
+	// silent before we take its beacons in the other
+	familySilence = 3
+)
+
+// beaconConfig is how the node sets up its beacon backend
+type beaconConfig struct {
+	port     int           // UDP port of the beacons
+	interval time.Duration // Beacon interval, zero for the default
+	version  byte          // Beacon version to emit, zero to negotiate
+	cluster  string        // Cluster we're in, if any
+	secret   []byte        // Shared secret beacons are signed with, if any
+	verbose  bool
+}
+
+// beaconDiscovery discovers peers on the local network by UDP beacons
+type beaconDiscovery struct {
+	beaconConfig
+	beacon    *beacon.Beacon
+	uuid      []byte            // Our UUID, once announced
+	endpoint  string            // Our endpoint, once announced
+	tcpPort   uint16            // Port of our endpoint, for version 1
+	emit      byte              // Beacon version we emit, once announced
+	heard     map[string]heard  // Family we hear each peer in
+	stamps    map[string]uint64 // Last timestamp of each peer, when signed
+	pruned    time.Time         // When stamps was last pruned
+	sightings chan Sighting
+	done      chan struct{}
+	sync.Mutex
+
+	// Beacons rejected in shared secret mode, updated atomically
+	unauthenticated uint64
+	replayed        uint64
+}
+
+// heard is when and in which IP family we last heard a peer
+type heard struct {
+	ipv6 bool
+	at   time.Time
+}
+
+// newBeaconDiscovery creates a backend beaconing as configured
+func newBeaconDiscovery(b *beacon.Beacon, c beaconConfig) *beaconDiscovery {
+	return &beaconDiscovery{
+		beaconConfig: c,
+		beacon:       b,
+		heard:        make(map[string]heard),
+		stamps:       make(map[string]uint64),
+		sightings:    make(chan Sighting, 50),
+		done:         make(chan struct{}),
+	}
+}
+
+// Start starts listening to beacons of any version, or to the version 2
+// beacons of our cluster if we're in one; ours go out once we're announced.
+// Signed beacons are signed again on every interval, so they stay fresh.
+func (d *beaconDiscovery) Start() error {
+	if d.interval > 0 {
+		d.beacon.SetInterval(d.interval)
+	}
+	d.beacon.SetPort(d.port)
+	d.beacon.NoEcho()
+	d.beacon.Subscribe(clusterFilter(d.cluster))
+	err := d.beacon.Publish(nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(d.sightings)
+		for s := range d.beacon.Signals() {
+			signal := s.(*beacon.Signal)
+			sighting, ok := d.decode(signal)
+			if !ok || !d.first(sighting, signal.Addr) {
+				continue
+			}
+			// Beacons keep coming, drop them while the node is busy
+			select {
+			case d.sightings <- sighting:
+			default:
+			}
+		}
+	}()
+
+	if d.secret != nil {
+		go d.resign()
+	}
+
+	return nil
+}
+
+// Stop sends a beacon without port or endpoint, telling peers we're going
+// away, and closes the beacon.
+func (d *beaconDiscovery) Stop() error {
+	close(d.done)
+
+	d.Lock()
+	if d.uuid != nil {
+		// It must go out before the beacon is closed, the next
+		// interval would be too late
+		err := d.beacon.Send(d.encode(true, time.Now()))
+		if err != nil && d.verbose {
+			log.Printf("Can't send leaving beacon: %s", err)
+		}
+		d.uuid = nil
+	}
+	d.Unlock()
+	d.beacon.Close()
+
+	return nil
+}
+
+// resign signs our beacon again on every interval until we stop
+func (d *beaconDiscovery) resign() {
+	interval := d.interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.Lock()
+			if d.uuid != nil {
+				d.beacon.Publish(d.encode(false, time.Now()))
+			}
+			d.Unlock()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Announce starts beaconing the UUID and the endpoint. Unless the version is
+// set, we emit version 1 beacons, which Zyre understands, as long as they
+// can tell our endpoint, i.e. it's a TCP endpoint on the address we beacon
+// from, and we're neither in a cluster nor in shared secret mode; version 2
+// otherwise.
+func (d *beaconDiscovery) Announce(uuid, endpoint string) error {
+	id, err := hex.DecodeString(uuid)
+	if err != nil || len(id) != 16 {
+		return fmt.Errorf("%w: invalid UUID %q", ErrInvalidConfig, uuid)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	emit := d.version
+	host, port, ok := tcpHostPort(endpoint)
+	if emit == 0 {
+		emit = beaconV2
+		if ok && host.Equal(net.ParseIP(d.addr())) && d.cluster == "" && d.secret == nil {
+			emit = beaconV1
+		}
+	}
+	if emit == beaconV1 && !ok {
+		return fmt.Errorf("%w: can't beacon endpoint %q in version 1 beacons", ErrInvalidConfig, endpoint)
+	}
+	if emit == beaconV1 && d.cluster != "" {
+		return fmt.Errorf("%w: version 1 beacons can't tell the cluster", ErrInvalidConfig)
+	}
+	if emit == beaconV1 && d.secret != nil {
+		return fmt.Errorf("%w: version 1 beacons can't be signed", ErrInvalidConfig)
+	}
+
+	d.uuid = id
+	d.endpoint = endpoint
+	d.emit = emit
+	d.tcpPort = port
+
+	transmit := d.encode(false, time.Now())
+	if len(transmit) > beaconMax {
+		return fmt.Errorf("%w: endpoint %q doesn't fit in a beacon", ErrInvalidConfig, endpoint)
+	}
+
+	return d.beacon.Publish(transmit)
+}
+
+// Sightings returns the peers whose beacons we've received
+func (d *beaconDiscovery) Sightings() <-chan Sighting {
+	return d.sightings
+}
+
+// first tells whether a sighting is in the IP family we heard the peer in
+// first, so a dual stack peer is sighted once. We switch to the other family
+// when the first one has been silent for a while.
+func (d *beaconDiscovery) first(s Sighting, addr string) bool {
+	if s.Endpoint == "" {
+		delete(d.heard, s.UUID)
+		return true
+	}
+
+	interval := d.interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	ipv6 := net.ParseIP(addr).To4() == nil
+	h, ok := d.heard[s.UUID]
+	if ok && h.ipv6 != ipv6 && time.Since(h.at) < familySilence*interval {
+		return false
+	}
+	d.heard[s.UUID] = heard{ipv6: ipv6, at: time.Now()}
+
+	return true
+}
+
+// addr returns the IP address we're beaconing from, once started
+func (d *beaconDiscovery) addr() string {
+	return d.beacon.Addr()
+}
+
+// encode builds our beacon in the version we emit, signed at the given time
+// in shared secret mode
+func (d *beaconDiscovery) encode(leaving bool, at time.Time) []byte {
+	b := &aBeacon{}
+	b.Protocol[0] = 'Z'
+	b.Protocol[1] = 'R'
+	b.Protocol[2] = 'E'
+	b.Version = d.emit
+	b.Cluster = d.cluster
+	b.UUID = d.uuid
+	if !leaving {
+		b.Port = d.tcpPort
+		b.Endpoint = d.endpoint
+	}
+
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, b.Protocol)
+	binary.Write(buffer, binary.BigEndian, b.Version)
+	if b.Version == beaconV1 {
+		binary.Write(buffer, binary.BigEndian, b.UUID)
+		binary.Write(buffer, binary.BigEndian, b.Port)
+	} else {
+		putBeaconString(buffer, b.Cluster)
+		binary.Write(buffer, binary.BigEndian, b.UUID)
+		putBeaconString(buffer, b.Endpoint)
+		if d.secret != nil {
+			signBeacon(buffer, d.secret, at)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// decode turns a beacon received from a peer into a sighting
+func (d *beaconDiscovery) decode(s *beacon.Signal) (Sighting, bool) {
+	b, err := parseBeacon(s.Transmit)
+	if err != nil {
+		if d.verbose {
+			log.Printf("Received %s", err)
+		}
+		return Sighting{}, false
+	}
+
+	// In shared secret mode, anyone may send beacons but only the
+	// nodes knowing the secret are sighted
+	if d.secret != nil && !d.authenticate(b) {
+		if d.verbose {
+			log.Printf("Rejected a beacon from %s which isn't signed or fresh", s.Addr)
+		}
+		return Sighting{}, false
+	}
+
+	// Version 1 beacons are from nodes which aren't in any cluster
+	if b.Cluster != d.cluster {
+		if d.verbose {
+			log.Printf("Received a beacon from cluster %q", b.Cluster)
+		}
+		return Sighting{}, false
+	}
+
+	sighting := Sighting{UUID: fmt.Sprintf("%X", b.UUID)}
+
+	if b.Version == beaconV1 {
+		// Zero port means peer is going away
+		if b.Port != 0 {
+			// s.Addr is IP address of peer beacon
+			sighting.Endpoint = tcpEndpoint(s.Addr, b.Port)
+		}
+	} else {
+		// The peer tells its endpoint, none means it's going away
+		sighting.Endpoint = b.Endpoint
+	}
+	if sighting.Endpoint != "" {
+		sighting.LocalAddr = d.beacon.LocalAddr(s)
+	}
+
+	return sighting, true
+}
+
+// parseBeacon parses a beacon of any version we know
+func parseBeacon(transmit []byte) (*aBeacon, error) {
+	b := &aBeacon{}
+	buffer := bytes.NewBuffer(transmit)
+	err := binary.Read(buffer, binary.BigEndian, &b.Protocol)
+	if err == nil {
+		err = binary.Read(buffer, binary.BigEndian, &b.Version)
+	}
+	if err != nil || string(b.Protocol[:]) != "ZRE" {
+		return nil, errors.New("a beacon which isn't ZRE")
+	}
+
+	// Ignore anything that isn't a valid beacon
+	uuid := make([]byte, 16)
+	switch b.Version {
+	case beaconV1:
+		err = binary.Read(buffer, binary.BigEndian, uuid)
+		if err == nil {
+			err = binary.Read(buffer, binary.BigEndian, &b.Port)
+		}
+	case beaconV2:
+		b.Cluster, err = getBeaconString(buffer)
+		if err == nil {
+			err = binary.Read(buffer, binary.BigEndian, uuid)
+		}
+		if err == nil {
+			b.Endpoint, err = getBeaconString(buffer)
+		}
+
+		// Signed beacons go on with the timestamp and the HMAC
+		if err == nil && buffer.Len() >= 8+beaconMACLen {
+			binary.Read(buffer, binary.BigEndian, &b.Timestamp)
+			b.signed = transmit[:len(transmit)-buffer.Len()]
+			b.MAC = buffer.Next(beaconMACLen)
+		}
+	default:
+		return nil, fmt.Errorf("a beacon with invalid version number %d", b.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("a truncated version %d beacon", b.Version)
+	}
+	b.UUID = uuid
+
+	return b, nil
+}
+
+// putBeaconString writes a string prefixed by its length
+func putBeaconString(buffer *bytes.Buffer, s string) {
+	buffer.WriteByte(byte(len(s)))
+	buffer.WriteString(s)
+}
+
+// getBeaconString reads a string prefixed by its length
+func getBeaconString(buffer *bytes.Buffer) (string, error) {
+	size, err := buffer.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	s := buffer.Next(int(size))
+	if len(s) < int(size) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	return string(s), nil
+}
+
+// tcpHostPort returns the IP address and port of a TCP endpoint; the address
+// is nil if the endpoint has a host name
+func tcpHostPort(endpoint string) (net.IP, uint16, bool) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "tcp" {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil || port == 0 {
+		return nil, 0, false
+	}
+
+	return net.ParseIP(u.Hostname()), uint16(port), true
+}
+
+// tcpEndpoint returns the TCP endpoint of the IP address and port
+func tcpEndpoint(addr string, port uint16) string {
+	ip := net.ParseIP(addr)
+	if ip.To4() == nil {
+		return fmt.Sprintf("tcp://[%s]:%d", ip.String(), port)
+	}
+	return fmt.Sprintf("tcp://%s:%d", ip.String(), port)
+}
+
+// gossipDiscovery discovers peers through the gossip network. Our tuple lives
+// for gossipTTL unless we publish it again, so peers which crash are forgotten
+// in the end; when we stop we withdraw it right away.
+type gossipDiscovery struct {
+	gossip    *zgossip.Gossip
+	cluster   string // Cluster we're in, if any
+	sightings chan Sighting
+	done      chan struct{}
+	uuid      string // Our UUID, once announced
+	endpoint  string // Our endpoint, once announced
+	sync.Mutex
+}
+
+// newGossipDiscovery creates a backend on top of the gossip engine
+func newGossipDiscovery(gossip *zgossip.Gossip, cluster string) *gossipDiscovery {
+	return &gossipDiscovery{
+		gossip:    gossip,
+		cluster:   cluster,
+		sightings: make(chan Sighting, 50),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start starts passing on the tuples of our cluster gossiped to us, a
+// withdrawn or expired tuple means the peer is going away. Our own tuple is
+// published again every third of its time to live.
+func (d *gossipDiscovery) Start() error {
+	go func() {
+		ticker := time.NewTicker(gossipTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case t, ok := <-d.gossip.Tuples():
+				if !ok {
+					return
+				}
+				cluster, uuid := parseGossipKey(t.Key)
+				if cluster != d.cluster {
+					continue
+				}
+				select {
+				case d.sightings <- Sighting{UUID: uuid, Endpoint: t.Value}:
+				case <-d.done:
+					return
+				}
+			case <-ticker.C:
+				d.Lock()
+				if d.uuid != "" {
+					d.gossip.Publish(gossipKey(d.cluster, d.uuid), d.endpoint, gossipTTL)
+				}
+				d.Unlock()
+			case <-d.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop withdraws our tuple and stops passing on the gossip
+func (d *gossipDiscovery) Stop() error {
+	close(d.done)
+
+	d.Lock()
+	defer d.Unlock()
+	if d.uuid == "" {
+		return nil
+	}
+
+	// Don't publish it again once it's withdrawn
+	uuid := d.uuid
+	d.uuid = ""
+	return d.gossip.Withdraw(gossipKey(d.cluster, uuid))
+}
+
+// Announce gossips our UUID and endpoint to the other nodes
+func (d *gossipDiscovery) Announce(uuid, endpoint string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.uuid = uuid
+	d.endpoint = endpoint
+	return d.gossip.Publish(gossipKey(d.cluster, uuid), endpoint, gossipTTL)
+}
+
+// Sightings returns the peers gossiped to us
+func (d *gossipDiscovery) Sightings() <-chan Sighting {
+	return d.sightings
+}
+
+// startDiscovery starts the built-in backends the node is configured for and
+// the ones set by the application, and announces the node through them.
+func (n *node) startDiscovery() (err error) {
+	var backends []Discovery
+	var bd *beaconDiscovery
+	if n.beaconPort > 0 {
+		bd = newBeaconDiscovery(n.beacon, beaconConfig{
+			port:     n.beaconPort,
+			interval: n.interval,
+			version:  n.beaconVersion,
+			cluster:  n.cluster,
+			secret:   n.beaconSecret,
+			verbose:  n.verbose,
+		})
+		backends = append(backends, bd)
+		n.beaconing = bd
+	}
+	if n.gossip != nil {
+		backends = append(backends, newGossipDiscovery(n.gossip, n.cluster))
+	}
+	backends = append(backends, n.discoveries...)
+
+	if len(backends) == 0 && len(n.static) == 0 {
+		return fmt.Errorf("%w: no discovery, use SetEndpoint, GossipBind, GossipConnect, SetStaticPeers or SetDiscovery", ErrInvalidConfig)
+	}
+
+	for _, d := range backends {
+		err = d.Start()
+		if err != nil {
+			n.stopDiscovery()
+			return err
+		}
+		n.started = append(n.started, d)
+		go n.relaySightings(d.Sightings(), n.terminated)
+	}
+
+	// Our own host endpoint is provided by the beacon, if any
+	if n.endpoint == "" {
+		if bd != nil {
+			n.endpoint = tcpEndpoint(bd.addr(), n.port)
+		} else {
+			hostname, err := os.Hostname()
+			if err != nil {
+				n.stopDiscovery()
+				return err
+			}
+			n.endpoint = fmt.Sprintf("tcp://%s:%d", hostname, n.port)
+		}
+	}
+
+	for _, d := range n.started {
+		err = d.Announce(n.identity(), n.endpoint)
+		if err != nil {
+			n.stopDiscovery()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopDiscovery stops the backends which have been started
+func (n *node) stopDiscovery() {
+	for _, d := range n.started {
+		err := d.Stop()
+		if err != nil && n.verbose {
+			log.Printf("[%s] Can't stop discovery: %s", n.name, err)
+		}
+	}
+	n.started = nil
+}
+
+// setDiscovery sets the discovery backends run alongside the built-in ones
+func (n *node) setDiscovery(backends []Discovery) error {
+	if n.started != nil {
+		return fmt.Errorf("%w: discovery must be set before the node is started", ErrInvalidConfig)
+	}
+	for _, d := range backends {
+		if d == nil {
+			return fmt.Errorf("%w: nil discovery", ErrInvalidConfig)
+		}
+	}
+	n.discoveries = backends
+
+	return nil
+}
+
+// relaySightings passes the sightings of a backend on to the node's goroutine
+// until the backend closes the channel or the node is shut down.
+func (n *node) relaySightings(sightings <-chan Sighting, terminated chan interface{}) {
+	for {
+		select {
+		case s, ok := <-sightings:
+			if !ok {
+				return
+			}
+			select {
+			case n.sightings <- s:
+			case <-terminated:
+				return
+			}
+		case <-terminated:
+			return
+		}
+	}
+}
+
+// recvSighting connects to a peer sighted by one of the backends
+func (n *node) recvSighting(s Sighting) {
+	identity, err := parseIdentity(s.UUID)
+	if err != nil {
+		if n.verbose {
+			log.Printf("[%s] Sighted %s", n.name, err)
+		}
+		return
+	}
+
+	// The gossip network tells us about ourselves as well
+	if identity == n.identity() || s.Endpoint == n.endpoint {
+		return
+	}
+
+	if s.Endpoint == "" {
+		// The peer is going away; remove it if we had any knowledge
+		// of it already
+		n.removePeer(n.peers[identity])
+		return
+	}
+
+	// Peers from other clusters stay away for a while, see SetCluster
+	if n.isRejected(identity) {
+		return
+	}
+
+	// When we listen on all interfaces, the peer should connect back over
+	// the network it was sighted on
+	var local string
+	if s.LocalAddr != "" && n.port != 0 {
+		local = tcpEndpoint(s.LocalAddr, n.port)
+	}
+
+	peer, err := n.requirePeerVia(identity, s.Endpoint, local)
+	if err == nil {
+		n.refreshPeer(peer)
+	} else if n.verbose {
+		log.Printf("[%s] %s", n.name, err)
+	}
+}
@@ -0,0 +1,276 @@
+package gyre
+
+import (
+	"log"
+	"time"
+
+	"github.com/zeromq/gyre/zre/msg"
+)
+
+// election is the state of the leader election in one of our groups. It's
+// the echo algorithm with extinction: each contestant starts a wave of ELECT
+// messages carrying its UUID, waves of smaller UUIDs die out and the
+// contestant whose wave comes back from every peer in the group wins.
+type election struct {
+	caw       string          // Challenger of the wave we're part of
+	father    string          // Peer we echo the wave back to
+	echoes    map[string]bool // Peers which have sent us the wave
+	startedAt time.Time       // When we started or joined the wave
+	done      bool            // Our own wave has come back, we've won
+}
+
+// newElection creates the state of a wave
+func newElection(caw, father string) *election {
+	return &election{
+		caw:       caw,
+		father:    father,
+		echoes:    make(map[string]bool),
+		startedAt: time.Now(),
+	}
+}
+
+// startElection challenges the peers of a group we contest in, unless a
+// better challenger is already running.
+func (n *node) startElection(group string) {
+	if _, ok := n.ownGroups[group]; !ok || !n.contests[group] {
+		return
+	}
+
+	me := n.identity()
+	e := n.elections[group]
+	if e != nil && e.caw > me && n.inPeerGroup(group, e.caw) {
+		return
+	}
+	if e == nil || e.caw != me {
+		e = newElection(me, "")
+		n.elections[group] = e
+	}
+	e.done = false
+	e.startedAt = time.Now()
+
+	n.forwardElect(group, me, "")
+	n.checkElection(group)
+}
+
+// checkElection finishes our part once the wave has come from every peer in
+// the group: the challenger has won, anyone else echoes the wave back.
+func (n *node) checkElection(group string) {
+	e := n.elections[group]
+	if e == nil || e.done {
+		return
+	}
+	if g, ok := n.peerGroups[group]; ok {
+		for identity := range g.peers {
+			if !e.echoes[identity] {
+				return
+			}
+		}
+	}
+
+	if e.caw == n.identity() {
+		e.done = true
+		m := msg.NewLeader()
+		m.Group = group
+		m.LeaderID = e.caw
+		if g, ok := n.peerGroups[group]; ok {
+			g.send(m)
+		}
+		n.setLeader(group, e.caw)
+		return
+	}
+
+	if father, ok := n.peers[e.father]; ok {
+		m := msg.NewElect()
+		m.Group = group
+		m.ChallengerID = e.caw
+		father.send(m)
+	}
+}
+
+// checkElections restarts our own waves which haven't come back in time,
+// e.g. because a peer ignored them before it noticed the old leader was gone,
+// and forgets the peers which have left for good.
+func (n *node) checkElections() {
+	for group, e := range n.elections {
+		if !e.done && e.caw == n.identity() && time.Since(e.startedAt) > n.expired {
+			if n.verbose {
+				log.Printf("[%s] Election in %s has stalled, restarting", n.name, group)
+			}
+			n.startElection(group)
+		}
+	}
+
+	for _, g := range n.peerGroups {
+		n.pruneLeft(g)
+	}
+}
+
+// pruneLeft forgets the peers which have left the group once they've gone
+// away, no wave or leader we know of names them and their challenges have
+// had time to die out. Peers come back with new UUIDs, the group would keep
+// the old ones forever otherwise.
+func (n *node) pruneLeft(g *group) {
+	e := n.elections[g.name]
+	for identity, at := range g.left {
+		if _, ok := n.peers[identity]; ok || time.Since(at) <= n.expired {
+			continue
+		}
+		if n.leaders[g.name] == identity || (e != nil && (e.caw == identity || e.father == identity)) {
+			continue
+		}
+		delete(g.left, identity)
+	}
+}
+
+// recvElect handles an ELECT message from a peer
+func (n *node) recvElect(peer *peer, m *msg.Elect) {
+	if _, ok := n.ownGroups[m.Group]; !ok || n.hasLeft(m.Group, m.ChallengerID) {
+		return
+	}
+
+	me := n.identity()
+	e := n.elections[m.Group]
+	switch {
+	case e == nil || m.ChallengerID > e.caw:
+		// We're a better challenger ourselves, our wave wipes this one out
+		if n.contests[m.Group] && me > m.ChallengerID {
+			n.startElection(m.Group)
+			return
+		}
+		e = newElection(m.ChallengerID, peer.identity)
+		n.elections[m.Group] = e
+		e.echoes[peer.identity] = true
+		n.forwardElect(m.Group, m.ChallengerID, peer.identity)
+
+	case m.ChallengerID == e.caw:
+		e.echoes[peer.identity] = true
+		// The challenger has restarted its wave, pass it on to peers
+		// which may have joined the group since and answer it directly
+		if peer.identity == e.caw {
+			e.father = peer.identity
+			n.forwardElect(m.Group, m.ChallengerID, peer.identity)
+		}
+
+	default:
+		// The wave dies out here, tell the challenger who's leading
+		if leader, ok := n.leaders[m.Group]; ok {
+			reply := msg.NewLeader()
+			reply.Group = m.Group
+			reply.LeaderID = leader
+			peer.send(reply)
+		}
+		return
+	}
+
+	n.checkElection(m.Group)
+}
+
+// recvLeader handles a LEADER message from a peer
+func (n *node) recvLeader(peer *peer, m *msg.Leader) {
+	if _, ok := n.ownGroups[m.Group]; !ok || n.hasLeft(m.Group, m.LeaderID) {
+		return
+	}
+
+	// A better challenger's wave is running, the news is stale
+	e := n.elections[m.Group]
+	if e != nil && m.LeaderID < e.caw {
+		return
+	}
+	if e == nil || m.LeaderID > e.caw {
+		n.elections[m.Group] = newElection(m.LeaderID, peer.identity)
+	}
+	n.setLeader(m.Group, m.LeaderID)
+
+	// The leader was elected without us, challenge it
+	if n.contests[m.Group] && n.identity() > m.LeaderID {
+		n.startElection(m.Group)
+	}
+}
+
+// electionPeerJoined challenges a peer which has joined the group, or passes
+// on the wave we're part of to it.
+func (n *node) electionPeerJoined(group string, peer *peer) {
+	n.startElection(group)
+
+	if e, ok := n.elections[group]; ok && e.caw != n.identity() {
+		m := msg.NewElect()
+		m.Group = group
+		m.ChallengerID = e.caw
+		peer.send(m)
+	}
+}
+
+// electionPeerLeft runs the election again if a peer leaving the group
+// takes the leader or the wave we're part of with it.
+func (n *node) electionPeerLeft(group, identity string) {
+	e := n.elections[group]
+	if n.leaders[group] == identity || (e != nil && (e.caw == identity || e.father == identity)) {
+		delete(n.leaders, group)
+		delete(n.elections, group)
+		n.startElection(group)
+		return
+	}
+
+	// We may have been waiting for it only
+	n.checkElection(group)
+}
+
+// forwardElect sends the wave of the challenger to every peer in the group
+// but the one it came from.
+func (n *node) forwardElect(group, challenger, from string) {
+	g, ok := n.peerGroups[group]
+	if !ok {
+		return
+	}
+
+	m := msg.NewElect()
+	m.Group = group
+	m.ChallengerID = challenger
+	for identity, peer := range g.peers {
+		if identity != from {
+			peer.send(msg.Clone(m))
+		}
+	}
+}
+
+// setLeader records the leader of a group and tells the caller about it
+func (n *node) setLeader(group, leader string) {
+	if n.leaders[group] == leader {
+		return
+	}
+	n.leaders[group] = leader
+
+	name := n.name
+	if peer, ok := n.peers[leader]; ok {
+		name = peer.name
+	}
+
+	select {
+	case n.events <- &Event{eventType: EventLeader, sender: leader, name: name, group: group}:
+	default:
+		if n.verbose {
+			log.Printf("[%s] Dropping event: %s", n.name, EventLeader)
+		}
+	}
+}
+
+// hasLeft tells whether the peer has left the group, or has gone away
+// altogether. Its challenges may still be on their way from other peers.
+func (n *node) hasLeft(group, identity string) bool {
+	g, ok := n.peerGroups[group]
+	if !ok {
+		return false
+	}
+	_, ok = g.left[identity]
+	return ok
+}
+
+// inPeerGroup tells whether the peer is in the group
+func (n *node) inPeerGroup(group, identity string) bool {
+	g, ok := n.peerGroups[group]
+	if !ok {
+		return false
+	}
+	_, ok = g.peers[identity]
+	return ok
+}
@@ -0,0 +1,31 @@
+package gyre
+
+import (
+	"errors"
+)
+
+// Errors returned by Gyre. They're usually wrapped together with the name of
+// the command which failed, use errors.Is to check for them.
+var (
+	// ErrNodeNotResponding is returned when the node didn't accept a command
+	// or didn't reply to it before the timeout or the context deadline.
+	ErrNodeNotResponding = errors.New("node is not responding")
+
+	// ErrInvalidReply is returned when the node replied with an unexpected
+	// payload.
+	ErrInvalidReply = errors.New("invalid reply")
+
+	// ErrNotStarted is returned by calls which only make sense after the
+	// node (or its gossip engine) has been started.
+	ErrNotStarted = errors.New("node is not started")
+
+	// ErrPeerNotFound is returned when the specified peer isn't known.
+	ErrPeerNotFound = errors.New("peer doesn't exist")
+
+	// ErrInvalidConfig is returned by New when the options are invalid or
+	// inconsistent with each other.
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// ErrHeaderNotFound is returned when the requested header isn't set.
+	ErrHeaderNotFound = errors.New("header doesn't exist")
+)
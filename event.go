@@ -11,6 +11,12 @@ const (
 	EventExit
 	EventWhisper
 	EventShout
+	EventEvasive
+	EventSilent
+	EventStop
+	EventAlive
+	EventLost
+	EventLeader
 )
 
 // Converts EventType to string.
@@ -28,6 +34,18 @@ func (e EventType) String() string {
 		return "EventWhisper"
 	case EventShout:
 		return "EventShout"
+	case EventEvasive:
+		return "EventEvasive"
+	case EventSilent:
+		return "EventSilent"
+	case EventStop:
+		return "EventStop"
+	case EventAlive:
+		return "EventAlive"
+	case EventLost:
+		return "EventLost"
+	case EventLeader:
+		return "EventLeader"
 	}
 
 	return ""
@@ -42,7 +60,24 @@ type Event struct {
 	address   string            // Sender ipaddress as string, for an ENTER event
 	headers   map[string]string // Headers, for an ENTER event
 	group     string            // Group name for a SHOUT event
-	msg       []byte            // Message payload for SHOUT or WHISPER
+	msg       []byte            // First frame of the message for SHOUT or WHISPER
+	frames    [][]byte          // All the frames of the message for SHOUT or WHISPER
+}
+
+// newContentEvent creates a SHOUT or WHISPER event carrying a message
+func newContentEvent(eventType EventType, sender, name, group string, frames [][]byte) *Event {
+	e := &Event{
+		eventType: eventType,
+		sender:    sender,
+		name:      name,
+		group:     group,
+		frames:    frames,
+	}
+	if len(frames) > 0 {
+		e.msg = frames[0]
+	}
+
+	return e
 }
 
 // Type returns event type, which is a EventType.
@@ -82,7 +117,13 @@ func (e *Event) Group() string {
 	return e.group
 }
 
-// Msg returns the incoming message payload (currently one frame).
+// Msg returns the first frame of the incoming message payload. Use Frames
+// for multi-frame messages.
 func (e *Event) Msg() []byte {
 	return e.msg
 }
+
+// Frames returns all the frames of the incoming message payload.
+func (e *Event) Frames() [][]byte {
+	return e.frames
+}
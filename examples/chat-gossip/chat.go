@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -20,10 +19,8 @@ func (e *endpoints) String() string {
 }
 
 // Set is the method to set the flag value, part of the flag.Value interface.
+// The flag may be given several times, or with comma separated endpoints.
 func (e *endpoints) Set(value string) error {
-	if len(*e) > 0 {
-		return errors.New("endpoints flag already set")
-	}
 	for _, rawurl := range strings.Split(value, ",") {
 		u, err := url.Parse(rawurl)
 		if err != nil {
@@ -42,20 +39,19 @@ var (
 )
 
 func chat() {
-	node, err := gyre.New()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer node.Stop()
-
+	var opts []gyre.Option
 	if gossipConnect != nil {
-		for _, u := range gossipConnect {
-			node.GossipConnect(u)
-		}
+		opts = append(opts, gyre.WithGossipConnect(gossipConnect...))
 	}
 	if *gossipBind != "" {
-		node.GossipBind(*gossipBind)
+		opts = append(opts, gyre.WithGossipBind(*gossipBind))
+	}
+
+	node, err := gyre.New(opts...)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	defer node.Stop()
 
 	err = node.Start()
 	if err != nil {
@@ -78,7 +74,7 @@ func chat() {
 
 func main() {
 
-	flag.Var(&gossipConnect, "gossip-connect", "A node may connect to multiple other nodes, for redundancy")
+	flag.Var(&gossipConnect, "gossip-connect", "A node may connect to multiple other nodes, for redundancy; repeat the flag or separate endpoints with commas")
 	flag.Parse()
 
 	go chat()
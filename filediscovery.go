@@ -0,0 +1,244 @@
+package gyre
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often a FileDiscovery refreshes its file and looks for others
+	fileHeartbeat = 1 * time.Second
+
+	// How long a file may go without a heartbeat before it's stale
+	fileExpired = 5 * time.Second
+)
+
+// FileDiscovery is a Discovery backend for nodes sharing a directory, e.g. on
+// a single host or in tests where multicast is unreliable. Each node writes
+// its endpoint into a file named after its UUID and rewrites it on each
+// heartbeat. The other files in the directory are the peers; files which
+// haven't been rewritten for a while are stale and get removed. The nodes
+// should use endpoints the others can reach, such as ipc:// or
+// tcp://127.0.0.1, see SetEndpoint.
+type FileDiscovery struct {
+	dir       string
+	heartbeat time.Duration   // How often we rewrite our file and scan
+	expired   time.Duration   // When files without heartbeat are stale
+	verbose   bool            // Log files we can't read or remove
+	uuid      string          // Our UUID, once announced
+	endpoint  string          // Our endpoint, once announced
+	seen      map[string]bool // Peers we've sighted and not seen go
+	sightings chan Sighting
+	done      chan struct{}
+	stop      sync.Once
+	wg        sync.WaitGroup
+	sync.Mutex
+}
+
+// NewFileDiscovery creates a backend sharing the given directory, which is
+// created if need be.
+func NewFileDiscovery(dir string) *FileDiscovery {
+	return &FileDiscovery{
+		dir:       dir,
+		heartbeat: fileHeartbeat,
+		expired:   fileExpired,
+		seen:      make(map[string]bool),
+		sightings: make(chan Sighting, 50),
+		done:      make(chan struct{}),
+	}
+}
+
+// SetHeartbeat sets how often the node's file is rewritten and the directory
+// scanned. Defaults to 1 second.
+func (d *FileDiscovery) SetHeartbeat(heartbeat time.Duration) *FileDiscovery {
+	d.heartbeat = heartbeat
+	return d
+}
+
+// SetExpired sets how long a file may go without a heartbeat before it's
+// considered stale. Defaults to 5 seconds.
+func (d *FileDiscovery) SetExpired(expired time.Duration) *FileDiscovery {
+	d.expired = expired
+	return d
+}
+
+// SetVerbose logs the files which can't be read, written or removed.
+func (d *FileDiscovery) SetVerbose() *FileDiscovery {
+	d.verbose = true
+	return d
+}
+
+// Start creates the directory and starts scanning it.
+func (d *FileDiscovery) Start() error {
+	err := os.MkdirAll(d.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return nil
+}
+
+// Stop removes our file and stops scanning. It's safe to call Stop more than
+// once, but a stopped FileDiscovery can't be started again, create a new one
+// instead.
+func (d *FileDiscovery) Stop() error {
+	d.stop.Do(func() { close(d.done) })
+	d.wg.Wait()
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.uuid == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(d.dir, d.uuid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Announce writes our file.
+func (d *FileDiscovery) Announce(uuid, endpoint string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.uuid = uuid
+	d.endpoint = endpoint
+
+	return d.write()
+}
+
+// Sightings returns the peers found in the directory.
+func (d *FileDiscovery) Sightings() <-chan Sighting {
+	return d.sightings
+}
+
+// run rewrites our file and scans the directory on each heartbeat
+func (d *FileDiscovery) run() {
+	defer d.wg.Done()
+	defer close(d.sightings)
+
+	ticker := time.NewTicker(d.heartbeat)
+	defer ticker.Stop()
+
+	d.scan()
+	for {
+		select {
+		case <-ticker.C:
+			d.Lock()
+			if d.uuid != "" {
+				// The file may have been removed by a peer which
+				// thought it stale, write it rather than touch it
+				if err := d.write(); err != nil && d.verbose {
+					log.Printf("Can't write discovery file: %s", err)
+				}
+			}
+			d.Unlock()
+			d.scan()
+
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// write replaces our file, so the peers never read half of it
+func (d *FileDiscovery) write() error {
+	tmp, err := ioutil.TempFile(d.dir, "."+d.uuid)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(d.endpoint + "\n")
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.dir, d.uuid))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// scan sights the peers whose files are in the directory, removes the stale
+// files and tells which peers have gone
+func (d *FileDiscovery) scan() {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		if d.verbose {
+			log.Printf("Can't scan discovery directory: %s", err)
+		}
+		return
+	}
+
+	d.Lock()
+	me := d.uuid
+	d.Unlock()
+
+	present := make(map[string]bool)
+	for _, fi := range files {
+		// Skip anything that isn't a peer, including our own file and
+		// the ones being written
+		name := fi.Name()
+		if fi.IsDir() || name == me {
+			continue
+		}
+		if _, err := parseIdentity(name); err != nil {
+			continue
+		}
+
+		path := filepath.Join(d.dir, name)
+		if time.Since(fi.ModTime()) > d.expired {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) && d.verbose {
+				log.Printf("Can't remove stale discovery file: %s", err)
+			}
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			if d.verbose && !os.IsNotExist(err) {
+				log.Printf("Can't read discovery file: %s", err)
+			}
+			continue
+		}
+		endpoint := strings.TrimSpace(string(content))
+		if endpoint == "" {
+			continue
+		}
+
+		present[name] = true
+		d.seen[name] = true
+		d.sight(Sighting{UUID: name, Endpoint: endpoint})
+	}
+
+	// Peers whose files have gone are going away
+	for uuid := range d.seen {
+		if !present[uuid] {
+			delete(d.seen, uuid)
+			d.sight(Sighting{UUID: uuid})
+		}
+	}
+}
+
+// sight passes a sighting on, unless we're stopping
+func (d *FileDiscovery) sight(s Sighting) {
+	select {
+	case d.sightings <- s:
+	case <-d.done:
+	}
+}
@@ -1,12 +1,15 @@
 package gyre
 
 import (
+	"time"
+
 	"github.com/zeromq/gyre/zre/msg"
 )
 
 type group struct {
-	name  string           // Group name
-	peers map[string]*peer // Peers in group
+	name  string               // Group name
+	peers map[string]*peer     // Peers in group
+	left  map[string]time.Time // Peers which have left the group, and when
 }
 
 // newGroup creates a new group
@@ -14,18 +17,21 @@ func newGroup(name string) *group {
 	return &group{
 		name:  name,
 		peers: make(map[string]*peer),
+		left:  make(map[string]time.Time),
 	}
 }
 
 // Join adds peer to group. Ignore duplicate joins
 func (g *group) join(peer *peer) {
 	g.peers[peer.identity] = peer
+	delete(g.left, peer.identity)
 	peer.status++
 }
 
 // Leave removes peer from group
 func (g *group) leave(peer *peer) {
 	delete(g.peers, peer.identity)
+	g.left[peer.identity] = time.Now()
 	peer.status++
 }
 
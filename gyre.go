@@ -6,20 +6,25 @@
 package gyre
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/zeromq/gyre/beacon"
 )
 
 const (
-	timeout = 5 * time.Second
+	// defaultTimeout is used by the calls which don't take a context, or
+	// whose context has no deadline. See SetTimeout.
+	defaultTimeout = 5 * time.Second
 )
 
 // Gyre structure
 type Gyre struct {
 	cmds    chan interface{}
-	replies chan interface{}
 	events  chan *Event       // Receives incoming cluster events/traffic
+	timeout time.Duration     // How long to wait for the node to respond
 	uuid    string            // Copy of our uuid
 	name    string            // Copy of our name
 	addr    string            // Copy of our address
@@ -30,6 +35,7 @@ type cmd struct {
 	cmd     string
 	key     string
 	payload interface{}
+	replies chan *reply // Where the node sends the reply, if any
 }
 
 type reply struct {
@@ -39,26 +45,52 @@ type reply struct {
 }
 
 const (
-	cmdUUID          = "UUID"
-	cmdName          = "NAME"
-	cmdSetName       = "SET NAME"
-	cmdSetHeader     = "SET HEADER"
-	cmdSetVerbose    = "SET VERBOSE"
-	cmdSetPort       = "SET PORT"
-	cmdSetInterval   = "SET INTERVAL"
-	cmdSetIface      = "SET INTERFACE"
-	cmdSetEndpoint   = "SET ENDPOINT"
-	cmdGossipBind    = "GOSSIP BIND"
-	cmdGossipPort    = "GOSSIP PORT"
-	cmdGossipConnect = "GOSSIP CONNECT"
-	cmdStart         = "START"
-	cmdStop          = "STOP"
-	cmdWhisper       = "WHISPER"
-	cmdShout         = "SHOUT"
-	cmdJoin          = "JOIN"
-	cmdLeave         = "LEAVE"
-	cmdDump          = "DUMP"
-	cmdTerm          = "$TERM"
+	cmdUUID             = "UUID"
+	cmdName             = "NAME"
+	cmdSetName          = "SET NAME"
+	cmdSetHeader        = "SET HEADER"
+	cmdSetVerbose       = "SET VERBOSE"
+	cmdSetPort          = "SET PORT"
+	cmdSetInterval      = "SET INTERVAL"
+	cmdSetIface         = "SET INTERFACE"
+	cmdSetFamily        = "SET FAMILY"
+	cmdSetBeaconVersion = "SET BEACON VERSION"
+	cmdSetCluster       = "SET CLUSTER"
+	cmdSetBeaconSecret  = "SET BEACON SECRET"
+	cmdSetBeaconConn    = "SET BEACON CONN"
+	cmdBeaconRejections = "BEACON REJECTIONS"
+	cmdSetEndpoint      = "SET ENDPOINT"
+	cmdSetCertificate   = "SET CERTIFICATE"
+	cmdSetPublicKey     = "SET PUBLIC KEY"
+	cmdSetAuthenticator = "SET AUTHENTICATOR"
+	cmdSetStaticPeers   = "SET STATIC PEERS"
+	cmdSetDiscovery     = "SET DISCOVERY"
+	cmdSetEvasive       = "SET EVASIVE"
+	cmdSetExpired       = "SET EXPIRED"
+	cmdSetLoopInterval  = "SET LOOP INTERVAL"
+	cmdGossipBind       = "GOSSIP BIND"
+	cmdGossipPort       = "GOSSIP PORT"
+	cmdGossipConnect    = "GOSSIP CONNECT"
+	cmdGossipHubs       = "GOSSIP HUBS"
+	cmdStart            = "START"
+	cmdStop             = "STOP"
+	cmdWhisper          = "WHISPER"
+	cmdShout            = "SHOUT"
+	cmdJoin             = "JOIN"
+	cmdLeave            = "LEAVE"
+	cmdRequirePeer      = "REQUIRE PEER"
+	cmdSetContest       = "SET CONTEST"
+	cmdDump             = "DUMP"
+	cmdPeers            = "PEERS"
+	cmdPeersByGroup     = "PEERS BY GROUP"
+	cmdPeerGroups       = "PEER GROUPS"
+	cmdOwnGroups        = "OWN GROUPS"
+	cmdPeerName         = "PEER NAME"
+	cmdPeerAddress      = "PEER ADDRESS"
+	cmdPeerState        = "PEER STATE"
+	cmdPeerHeader       = "PEER HEADER"
+	cmdPeerHeaders      = "PEER HEADERS"
+	cmdTerm             = "$TERM"
 
 	// Deprecated
 	cmdAddr    = "ADDR"
@@ -66,29 +98,61 @@ const (
 	cmdHeaders = "HEADERS"
 )
 
+// IP families the beacon works on, see SetIPv4Only, SetIPv6Only and
+// SetDualStack
+const (
+	familyIPv4 = "IPV4"
+	familyIPv6 = "IPV6"
+	familyDual = "DUAL"
+)
+
 // New creates a new Gyre node. Note that until you start the
 // node it is silent and invisible to other nodes on the network.
-func New() (g *Gyre, err error) {
-	g, _, err = newGyre()
+// The node can be configured by passing options, e.g.
+//
+//	node, err := gyre.New(gyre.WithName("sensor"), gyre.WithInterface("eth0"))
+//
+// The whole configuration is validated before the node is created.
+func New(opts ...Option) (g *Gyre, err error) {
+	g, _, err = newGyre(opts...)
 	return
 }
 
 // New creates a new Gyre node. This methods returns node object as well which is
 // used for testing purposes
-func newGyre() (*Gyre, *node, error) {
+func newGyre(opts ...Option) (*Gyre, *node, error) {
+	c := newConfig()
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+
 	g := &Gyre{
 		// The following channels are used in nodeActor() method which is heart of the Gyre
 		// if something blocks while sending to one of these channels, it'll cause pause in
 		// the system which isn't desired.
 		events:  make(chan *Event, 10000), // Do not block on sending events
 		cmds:    make(chan interface{}),   // Shouldn't be a buffered channel because the main select acts as a lock
-		replies: make(chan interface{}),
+		timeout: c.timeout,
 		headers: make(map[string]string),
 	}
 
-	n, err := newNode(g.events, g.cmds, g.replies)
+	n, err := newNode(g.events, g.cmds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The actor isn't running yet, so it's safe to touch the node. If the
+	// configuration can't be applied, tear down whatever it has set up so
+	// far, e.g. the gossip engine or the ZAP domain.
+	err = c.apply(n)
 	if err != nil {
-		return g, nil, err
+		n.terminate()
+		return nil, nil, err
 	}
 
 	go n.actor()
@@ -96,6 +160,13 @@ func newGyre() (*Gyre, *node, error) {
 	return g, n, nil
 }
 
+// SetTimeout sets how long the calls which don't take a context wait for the
+// node to accept a command and reply to it. It's also applied to the
+// contexts without a deadline. Defaults to 5 seconds.
+func (g *Gyre) SetTimeout(timeout time.Duration) {
+	g.timeout = timeout
+}
+
 // UUID returns our node UUID, after successful initialization
 func (g *Gyre) UUID() string {
 	uuid, err := g.nodeUUID()
@@ -111,24 +182,11 @@ func (g *Gyre) nodeUUID() (string, error) {
 		return g.uuid, nil
 	}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdUUID}:
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdUUID)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return "", fmt.Errorf("%s command replied with an invalid reply", cmdUUID)
-		} else if uuid, ok := out.payload.(string); ok {
-			g.uuid = uuid
-		} else {
-			return "", fmt.Errorf("%s command replied with an invalid payload", cmdUUID)
-		}
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdUUID)
+	uuid, err := g.requestString(context.Background(), &cmd{cmd: cmdUUID})
+	if err != nil {
+		return "", err
 	}
+	g.uuid = uuid
 
 	return g.uuid, nil
 }
@@ -149,53 +207,27 @@ func (g *Gyre) nodeName() (string, error) {
 		return g.name, nil
 	}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdName}:
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdName)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return "", fmt.Errorf("%s command replied with an invalid reply", cmdName)
-		} else if name, ok := out.payload.(string); ok {
-			g.name = name
-		} else {
-			return "", fmt.Errorf("%s command replied with an invalid payload", cmdName)
-		}
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdName)
+	name, err := g.requestString(context.Background(), &cmd{cmd: cmdName})
+	if err != nil {
+		return "", err
 	}
+	g.name = name
 
 	return g.name, nil
 }
 
-// Addr returns our address. Note that it will return empty string
+// Addr returns our address. Note that it will return ErrNotStarted
 // if called before Start() method.
 func (g *Gyre) Addr() (string, error) {
 	if g.addr != "" {
 		return g.addr, nil
 	}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdAddr}:
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdAddr)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return "", fmt.Errorf("%s command replied with an invalid reply", cmdAddr)
-		} else if addr, ok := out.payload.(string); ok {
-			g.addr = addr
-		} else {
-			return "", fmt.Errorf("%s command replied with an invalid payload", cmdAddr)
-		}
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdAddr)
+	addr, err := g.requestString(context.Background(), &cmd{cmd: cmdAddr})
+	if err != nil {
+		return "", err
 	}
+	g.addr = addr
 
 	return g.addr, nil
 }
@@ -207,308 +239,338 @@ func (g *Gyre) Header(key string) (string, bool) {
 		return header, ok
 	}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdHeader, key: key}:
-	case <-time.After(timeout):
-		log.Printf("Node is not responding to %s command", cmdSetHeader)
-		return "", false
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			log.Println(out.err)
-			return "", false
-		} else {
-			header, ok := out.payload.(string)
-			g.headers[key] = header
-
-			return header, ok
-		}
-
-	case <-time.After(timeout):
-		log.Printf("Node is not responding to %s command", cmdSetHeader)
+	header, err := g.requestString(context.Background(), &cmd{cmd: cmdHeader, key: key})
+	if err != nil {
+		log.Println(err)
 		return "", false
 	}
+	g.headers[key] = header
 
-	return "", false
+	return header, true
 }
 
 // Headers returns headers
 func (g *Gyre) Headers() (map[string]string, error) {
-
-	select {
-	case g.cmds <- &cmd{cmd: cmdHeaders}:
-	case <-time.After(timeout):
-		return nil, fmt.Errorf("Node is not responding to %s command", cmdHeaders)
+	out, err := g.request(context.Background(), &cmd{cmd: cmdHeaders})
+	if err != nil {
+		return nil, err
 	}
 
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); !ok {
-			return nil, fmt.Errorf("%s command replied with an invalid reply", cmdHeaders)
-		} else if headers, ok := out.payload.(map[string]string); ok {
-			return headers, nil
-		}
-		return nil, fmt.Errorf("%s command replied with an invalid payload", cmdHeaders)
-
-	case <-time.After(timeout):
-		return nil, fmt.Errorf("Node is not responding to %s command", cmdHeaders)
+	headers, ok := out.payload.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("%s command: %w", cmdHeaders, ErrInvalidReply)
 	}
 
-	return nil, nil
+	return headers, nil
 }
 
 // SetName sets node name; this is provided to other nodes during discovery.
 // If you do not set this, the UUID is used as a basis.
 func (g *Gyre) SetName(name string) error {
-
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetName, payload: name}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetName)
-	}
-
-	return nil
+	return g.send(context.Background(), &cmd{cmd: cmdSetName, payload: name})
 }
 
 // SetHeader sets node header; these are provided to other nodes during discovery
 // and come in each ENTER message.
 func (g *Gyre) SetHeader(name string, format string, args ...interface{}) error {
 	payload := fmt.Sprintf(format, args...)
+	return g.send(context.Background(), &cmd{cmd: cmdSetHeader, key: name, payload: payload})
+}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetHeader, key: name, payload: payload}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetHeader)
-	}
-
-	return nil
+// SetContestInGroup makes the node contest the leadership of the group. The
+// peers of the group contesting it elect a leader among themselves, which
+// comes in a LEADER event; the election is run again when the leader leaves.
+func (g *Gyre) SetContestInGroup(group string) error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetContest, key: group})
 }
 
 // SetVerbose sets verbose mode; this tells the node to log all traffic as well
 // as all major events.
 func (g *Gyre) SetVerbose() error {
-
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetVerbose, payload: true}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetVerbose)
-	}
-
-	return nil
+	return g.send(context.Background(), &cmd{cmd: cmdSetVerbose, payload: true})
 }
 
 // SetPort sets ZRE discovery port; defaults to 5670, this call overrides that
 // so you can create independent clusters on the same network, for e.g
-// development vs production.
+// development vs production. See SetCluster to keep clusters apart on the
+// same port.
 func (g *Gyre) SetPort(port int) error {
-
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetPort, payload: port}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetPort)
-	}
-
-	return nil
+	return g.send(context.Background(), &cmd{cmd: cmdSetPort, payload: port})
 }
 
 // SetInterval sets ZRE discovery interval. Default is instant beacon
 // exploration followed by pinging every 1,000 msecs.
 func (g *Gyre) SetInterval(interval time.Duration) error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetInterval, payload: interval})
+}
+
+// SetInterface sets the network interfaces to use for beacons and
+// interconnects. If you do not set this, Gyre beacons on every interface
+// which is up and can multicast. Each peer is greeted with our address on
+// the interface its beacon arrived on, so on boxes with multiple interfaces
+// the peers connect back over the network they saw us on.
+func (g *Gyre) SetInterface(ifaces ...string) error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetIface, payload: ifaces})
+}
+
+// SetIPv4Only makes the beacon work on IPv4 alone. By default it works on
+// IPv4, or on IPv6 if there's no IPv4.
+func (g *Gyre) SetIPv4Only() error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetFamily, payload: familyIPv4})
+}
+
+// SetIPv6Only makes the beacon work on IPv6 alone.
+func (g *Gyre) SetIPv6Only() error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetFamily, payload: familyIPv6})
+}
+
+// SetDualStack makes the beacon work on IPv4 and IPv6 at once, so nodes on
+// IPv6 only segments and IPv4 only hosts find each other on the same
+// network. A peer heard in both families is connected to once, in the
+// family it was heard in first.
+func (g *Gyre) SetDualStack() error {
+	return g.send(context.Background(), &cmd{cmd: cmdSetFamily, payload: familyDual})
+}
+
+// SetCluster makes the node part of a named cluster, so e.g. staging and
+// production nodes can share a network and port without finding each other.
+// It must be called before Start. The cluster is told in version 2 beacons,
+// in the gossiped keys and in the X-CLUSTER header of HELLO; peers from
+// other clusters, or from none, are rejected before the node connects to
+// them where the discovery tells their cluster, and on HELLO otherwise.
+// Peers rejected on HELLO are ignored for a minute.
+func (g *Gyre) SetCluster(name string) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetCluster, payload: name})
+	return err
+}
+
+// SetBeaconSecret switches the node to shared secret mode, where beacons
+// carry a timestamp and an HMAC made with the secret, and beacons which
+// aren't signed with it or aren't fresh are dropped. Otherwise any host can
+// make the nodes connect to it, or evict a peer, with a forged beacon. All
+// the nodes must share the secret and agree on the time within 30 seconds;
+// the beacons go out in version 2, which Zyre doesn't understand. It must be
+// called before Start, an empty secret switches the mode off.
+func (g *Gyre) SetBeaconSecret(secret string) error {
+	var key []byte
+	if secret != "" {
+		key = []byte(secret)
+	}
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetBeaconSecret, payload: key})
+	return err
+}
+
+// SetBeaconConn makes the beacons go through the given connections rather
+// than the network, e.g. through a beacon.Hub to simulate a network with
+// packet loss and partitions in tests. The port, interfaces and IP families
+// don't apply to the beacons then. It must be called before Start.
+func (g *Gyre) SetBeaconConn(conns ...beacon.PacketConn) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetBeaconConn, payload: conns})
+	return err
+}
+
+// BeaconRejections returns how many beacons the node has dropped in shared
+// secret mode, see SetBeaconSecret.
+func (g *Gyre) BeaconRejections() (BeaconRejections, error) {
+	out, err := g.request(context.Background(), &cmd{cmd: cmdBeaconRejections})
+	if err != nil {
+		return BeaconRejections{}, err
+	}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetInterval, payload: interval}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetInterval)
+	rejections, ok := out.payload.(BeaconRejections)
+	if !ok {
+		return BeaconRejections{}, fmt.Errorf("%s command: %w", cmdBeaconRejections, ErrInvalidReply)
 	}
 
-	return nil
+	return rejections, nil
 }
 
-// SetInterface sets network interface to use for beacons and interconnects. If you
-// do not set this, Gyre will choose an interface for you. On boxes
-// with multiple interfaces you really should specify which one you
-// want to use, or strange things can happen.
-func (g *Gyre) SetInterface(iface string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetIface, payload: iface}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetIface)
-	}
+// SetBeaconVersion sets the version of the beacons the node emits, it must be
+// called before Start. Version 1 beacons carry our port alone and peers
+// take our address from where the beacon comes from; version 2 beacons carry
+// the endpoint itself, which is right behind NAT, with several interfaces or
+// with transports other than TCP, but Zyre doesn't understand them. By
+// default, or with version 0, the node emits version 1 beacons as long as
+// they can tell its endpoint and version 2 otherwise. Beacons of both
+// versions are always accepted.
+func (g *Gyre) SetBeaconVersion(version int) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetBeaconVersion, payload: version})
+	return err
+}
 
-	return nil
+// SetEvasive sets the period of silence after which a peer is considered
+// evasive and gets pinged. The new value applies to a peer the next time
+// we hear from it.
+func (g *Gyre) SetEvasive(evasive time.Duration) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetEvasive, payload: evasive})
+	return err
+}
+
+// SetExpired sets the period of silence after which a peer is considered
+// gone. The new value applies to a peer the next time we hear from it.
+func (g *Gyre) SetExpired(expired time.Duration) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetExpired, payload: expired})
+	return err
+}
+
+// SetLoopInterval sets the interval of checking health of the peers. It
+// takes effect immediately, even if the node is already started.
+func (g *Gyre) SetLoopInterval(interval time.Duration) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetLoopInterval, payload: interval})
+	return err
 }
 
 // SetEndpoint sets the endpoint. By default, Gyre binds to an ephemeral TCP
 // port and broadcasts the local host name using UDP beaconing. When you call
 // this method, Gyre will use gossip discovery instead of UDP beaconing. You
 // MUST set-up the gossip service separately using GossipBind() and
-// GossipConnect(). Note that the endpoint MUST be valid for both bind and
-// connect operations. You can use inproc://, ipc://, or tcp:// transports
-// (for tcp://, use an IP address that is meaningful to remote as well as
-// local nodes).
+// GossipConnect(), or connect to peers using RequirePeer(). Note that the
+// endpoint MUST be valid for both bind and connect operations. You can use
+// inproc://, ipc://, or tcp:// transports (for tcp://, use an IP address
+// that is meaningful to remote as well as local nodes). To beacon the
+// endpoint as well, call SetPort afterwards; it goes out in version 2
+// beacons unless version 1 can tell it, see SetBeaconVersion.
 func (g *Gyre) SetEndpoint(endpoint string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdSetEndpoint, payload: endpoint}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetEndpoint)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return out.err
-		} else if !ok {
-			return fmt.Errorf("%s command replied with an invalid payload", cmdSetEndpoint)
-		}
-
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdSetEndpoint)
-	}
-
-	return nil
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetEndpoint, payload: endpoint})
+	return err
+}
+
+// SetCertificate sets the CURVE certificate of the node, as Z85 encoded keys.
+// Peers connect to us using CURVE security from then on and the public key is
+// advertised to them in the X-PUBLICKEY header. It must be called before
+// SetEndpoint and Start. Note that we can only connect to peers whose public
+// key we know, either from SetPublicKey or from their HELLO. Keys are learnt
+// from HELLO only when an Authenticator is set, and only if the peer has
+// authenticated with the key it advertises.
+func (g *Gyre) SetCertificate(publicKey, secretKey string) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetCertificate, key: publicKey, payload: secretKey})
+	return err
+}
+
+// SetPublicKey sets the Z85 encoded CURVE public key of the peer with the
+// given UUID, so we can connect to it once it's discovered.
+func (g *Gyre) SetPublicKey(peer, publicKey string) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetPublicKey, key: peer, payload: publicKey})
+	return err
+}
+
+// SetAuthenticator sets the hook deciding which CURVE keys may connect to the
+// node, e.g. an AllowList. By default any peer may connect. Authentication
+// needs the process-wide ZAP handler, so it doesn't mix with other ZAP
+// handlers such as zmq4.AuthStart.
+func (g *Gyre) SetAuthenticator(auth Authenticator) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetAuthenticator, payload: auth})
+	return err
+}
+
+// SetStaticPeers sets a static list of peer endpoints to discover peers from,
+// alongside or instead of beacons and gossip, e.g. where multicast is blocked.
+// The node keeps greeting each endpoint, backing off while nobody is there,
+// until the peer answers; it greets the endpoint again once the peer has gone.
+// The endpoints should be the ones the peers advertise, host names are
+// resolved once when the list is set. See LoadStaticPeers for reading the
+// list from a file.
+func (g *Gyre) SetStaticPeers(endpoints ...string) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetStaticPeers, payload: endpoints})
+	return err
+}
+
+// SetDiscovery sets discovery backends, e.g. a registry of the application's
+// own, to find peers through. They run alongside the UDP beacons, unless
+// disabled with SetPort(0), and the gossip network, if it's set up. It must
+// be called before the node is started.
+func (g *Gyre) SetDiscovery(backends ...Discovery) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdSetDiscovery, payload: backends})
+	return err
 }
 
 // GossipBind Sets up gossip discovery of other nodes. At least one node in
 // the cluster must bind to a well-known gossip endpoint, so other nodes
 // can connect to it. Note that gossip endpoints are completely distinct
 // from Gyre node endpoints, and should not overlap (they can use the same
-// transport).
+// transport). A node withdraws its endpoint from the gossip network when it
+// stops; the endpoint of a node which has gone without stopping expires
+// after 30 seconds.
 func (g *Gyre) GossipBind(endpoint string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdGossipBind, payload: endpoint}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdGossipBind)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return out.err
-		} else if !ok {
-			return fmt.Errorf("%s command replied with an invalid payload", cmdGossipBind)
-		}
-
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdGossipBind)
-	}
-
-	return nil
+	_, err := g.request(context.Background(), &cmd{cmd: cmdGossipBind, payload: endpoint})
+	return err
 }
 
 // GossipPort returns the port number that gossip engine is bound to
 func (g *Gyre) GossipPort() (string, error) {
-	select {
-	case g.cmds <- &cmd{cmd: cmdGossipPort}:
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdGossipPort)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return "", out.err
-		} else if !ok {
-			return "", fmt.Errorf("%s command replied with an invalid reply", cmdGossipPort)
-		} else if p, ok := out.payload.(string); ok {
-			return p, nil
-		}
-		return "", fmt.Errorf("%s command replied with an invalid payload", cmdGossipPort)
-
-	case <-time.After(timeout):
-		return "", fmt.Errorf("Node is not responding to %s command", cmdGossipPort)
-	}
-
-	return "", nil
+	return g.requestString(context.Background(), &cmd{cmd: cmdGossipPort})
 }
 
 // GossipConnect Sets up gossip discovery of other nodes. A node may connect
 // to multiple other nodes, for redundancy paths.
 func (g *Gyre) GossipConnect(endpoint string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdGossipConnect, payload: endpoint}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdGossipConnect)
+	_, err := g.request(context.Background(), &cmd{cmd: cmdGossipConnect, payload: endpoint})
+	return err
+}
+
+// GossipHubs returns the state of the gossip endpoints the node has connected
+// to. A hub which has gone silent is reconnected to, backing off
+// exponentially; discovery keeps working as long as any hub is reachable.
+func (g *Gyre) GossipHubs() ([]GossipHubState, error) {
+	out, err := g.request(context.Background(), &cmd{cmd: cmdGossipHubs})
+	if err != nil {
+		return nil, err
 	}
 
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return out.err
-		} else if !ok {
-			return fmt.Errorf("%s command replied with an invalid payload", cmdGossipConnect)
-		}
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdGossipConnect)
+	hubs, ok := out.payload.([]GossipHubState)
+	if !ok {
+		return nil, fmt.Errorf("%s command: %w", cmdGossipHubs, ErrInvalidReply)
 	}
 
-	return nil
+	return hubs, nil
 }
 
 // Start starts a node, after setting header values. When you start a node it
 // begins discovery and connection. Returns nil if OK, and error if
 // it wasn't possible to start the node.
 func (g *Gyre) Start() error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdStart}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdStart)
-	}
-
-	select {
-	case r := <-g.replies:
-		if out, ok := r.(*reply); ok && out.err != nil {
-			return out.err
-		} else if !ok {
-			return fmt.Errorf("%s command replied with an invalid payload", cmdStart)
-		}
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdStart)
-	}
+	return g.StartContext(context.Background())
+}
 
-	return nil
+// StartContext is like Start but gives up when ctx is done.
+func (g *Gyre) StartContext(ctx context.Context) error {
+	_, err := g.request(ctx, &cmd{cmd: cmdStart})
+	return err
 }
 
 // Stop stops a node; this signals to other peers that this node will go away.
 // This is polite; however you can also just destroy the node without
 // stopping it.
 func (g *Gyre) Stop() error {
+	return g.StopContext(context.Background())
+}
 
-	select {
-	case g.cmds <- &cmd{cmd: cmdStop}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdStop)
-	}
-
-	select {
-	case <-g.replies:
-	case <-time.After(20 * time.Millisecond):
-		return fmt.Errorf("Node is not responding to %s command", cmdStop)
-	}
-
-	return nil
+// StopContext is like Stop but gives up waiting for the node to shut down
+// when ctx is done.
+func (g *Gyre) StopContext(ctx context.Context) error {
+	_, err := g.request(ctx, &cmd{cmd: cmdStop})
+	return err
 }
 
 // Join a named group; after joining a group you can send messages to
 // the group and all Gyre nodes in that group will receive them.
 func (g *Gyre) Join(group string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdJoin, key: group}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdJoin)
-	}
-	return nil
+	return g.JoinContext(context.Background(), group)
+}
+
+// JoinContext is like Join but gives up when ctx is done.
+func (g *Gyre) JoinContext(ctx context.Context, group string) error {
+	return g.send(ctx, &cmd{cmd: cmdJoin, key: group})
 }
 
 // Leave a group.
 func (g *Gyre) Leave(group string) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdLeave, key: group}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdLeave)
-	}
-	return nil
+	return g.LeaveContext(context.Background(), group)
+}
+
+// LeaveContext is like Leave but gives up when ctx is done.
+func (g *Gyre) LeaveContext(ctx context.Context, group string) error {
+	return g.send(ctx, &cmd{cmd: cmdLeave, key: group})
 }
 
 // Events returns a channel of events. The events may be a control
@@ -517,54 +579,250 @@ func (g *Gyre) Events() chan *Event {
 	return g.events
 }
 
+// RequirePeer connects to a peer whose UUID and endpoint are known out of
+// band and says HELLO to it right away, without waiting for it to be
+// discovered. This lets nodes talk across networks where neither beacons nor
+// gossip get through. The node must be started, or have its endpoint set,
+// so the peer can connect back to us.
+func (g *Gyre) RequirePeer(uuid, endpoint string) error {
+	_, err := g.request(context.Background(), &cmd{cmd: cmdRequirePeer, key: uuid, payload: endpoint})
+	return err
+}
+
 // Whisper sends a message to single peer, specified as a UUID string.
 func (g *Gyre) Whisper(peer string, payload []byte) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdWhisper, key: peer, payload: payload}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdWhisper)
-	}
-	return nil
+	return g.WhisperContext(context.Background(), peer, payload)
+}
+
+// WhisperContext is like Whisper but gives up when ctx is done.
+func (g *Gyre) WhisperContext(ctx context.Context, peer string, payload []byte) error {
+	return g.WhisperFramesContext(ctx, peer, [][]byte{payload})
+}
+
+// WhisperFrames sends a multi-frame message to single peer, specified as a
+// UUID string.
+func (g *Gyre) WhisperFrames(peer string, frames [][]byte) error {
+	return g.WhisperFramesContext(context.Background(), peer, frames)
+}
+
+// WhisperFramesContext is like WhisperFrames but gives up when ctx is done.
+func (g *Gyre) WhisperFramesContext(ctx context.Context, peer string, frames [][]byte) error {
+	return g.send(ctx, &cmd{cmd: cmdWhisper, key: peer, payload: frames})
 }
 
 // Shout sends a message to a named group.
 func (g *Gyre) Shout(group string, payload []byte) error {
-	select {
-	case g.cmds <- &cmd{cmd: cmdShout, key: group, payload: payload}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdShout)
-	}
-	return nil
+	return g.ShoutContext(context.Background(), group, payload)
+}
+
+// ShoutContext is like Shout but gives up when ctx is done.
+func (g *Gyre) ShoutContext(ctx context.Context, group string, payload []byte) error {
+	return g.ShoutFramesContext(ctx, group, [][]byte{payload})
+}
+
+// ShoutFrames sends a multi-frame message to a named group.
+func (g *Gyre) ShoutFrames(group string, frames [][]byte) error {
+	return g.ShoutFramesContext(context.Background(), group, frames)
+}
+
+// ShoutFramesContext is like ShoutFrames but gives up when ctx is done.
+func (g *Gyre) ShoutFramesContext(ctx context.Context, group string, frames [][]byte) error {
+	return g.send(ctx, &cmd{cmd: cmdShout, key: group, payload: frames})
 }
 
 // Whispers sends a formatted string to a single peer specified as UUID string.
 func (g *Gyre) Whispers(peer string, format string, args ...interface{}) error {
 	payload := fmt.Sprintf(format, args...)
-	select {
-	case g.cmds <- &cmd{cmd: cmdWhisper, key: peer, payload: []byte(payload)}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %ss command", cmdWhisper)
-	}
-	return nil
+	return g.WhisperContext(context.Background(), peer, []byte(payload))
 }
 
 // Shouts sends a message to a named group.
 func (g *Gyre) Shouts(group string, format string, args ...interface{}) error {
 	payload := fmt.Sprintf(format, args...)
+	return g.ShoutContext(context.Background(), group, []byte(payload))
+}
+
+// Dump returns a snapshot of the node's state: our own identity, groups
+// and headers as well as every known peer along with its sequence numbers
+// and timers. In verbose mode the state is logged too.
+func (g *Gyre) Dump() (*NodeState, error) {
+	return g.DumpContext(context.Background())
+}
+
+// DumpContext is like Dump but gives up when ctx is done.
+func (g *Gyre) DumpContext(ctx context.Context) (*NodeState, error) {
+	out, err := g.request(ctx, &cmd{cmd: cmdDump})
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := out.payload.(*NodeState)
+	if !ok {
+		return nil, fmt.Errorf("%s command: %w", cmdDump, ErrInvalidReply)
+	}
+
+	return state, nil
+}
+
+// Peers returns the UUIDs of all the peers we're currently connected to.
+func (g *Gyre) Peers() ([]string, error) {
+	return g.requestStrings(context.Background(), &cmd{cmd: cmdPeers})
+}
+
+// PeersByGroup returns the UUIDs of the peers which are members of the
+// specified group.
+func (g *Gyre) PeersByGroup(group string) ([]string, error) {
+	return g.requestStrings(context.Background(), &cmd{cmd: cmdPeersByGroup, key: group})
+}
+
+// PeerGroups returns the names of all the groups our peers are in.
+func (g *Gyre) PeerGroups() ([]string, error) {
+	return g.requestStrings(context.Background(), &cmd{cmd: cmdPeerGroups})
+}
+
+// OwnGroups returns the names of the groups we're in.
+func (g *Gyre) OwnGroups() ([]string, error) {
+	return g.requestStrings(context.Background(), &cmd{cmd: cmdOwnGroups})
+}
+
+// PeerName returns the public name of the specified peer.
+func (g *Gyre) PeerName(peer string) (string, error) {
+	return g.requestString(context.Background(), &cmd{cmd: cmdPeerName, key: peer})
+}
+
+// PeerAddress returns the endpoint of the specified peer, e.g.
+// "tcp://10.0.0.2:49152".
+func (g *Gyre) PeerAddress(peer string) (string, error) {
+	return g.requestString(context.Background(), &cmd{cmd: cmdPeerAddress, key: peer})
+}
+
+// PeerLifecycle returns where the specified peer is in its lifecycle, e.g.
+// PeerEvasive if it has gone quiet.
+func (g *Gyre) PeerLifecycle(peer string) (PeerLifecycle, error) {
+	out, err := g.request(context.Background(), &cmd{cmd: cmdPeerState, key: peer})
+	if err != nil {
+		return 0, err
+	}
+
+	state, ok := out.payload.(PeerLifecycle)
+	if !ok {
+		return 0, fmt.Errorf("%s command: %w", cmdPeerState, ErrInvalidReply)
+	}
+
+	return state, nil
+}
+
+// PeerHeader returns the value of a header which the specified peer sent
+// us in its HELLO.
+func (g *Gyre) PeerHeader(peer string, key string) (string, error) {
+	return g.requestString(context.Background(), &cmd{cmd: cmdPeerHeader, key: peer, payload: key})
+}
+
+// PeerHeaders returns a copy of all the headers the specified peer sent us
+// in its HELLO.
+func (g *Gyre) PeerHeaders(peer string) (map[string]string, error) {
+	out, err := g.request(context.Background(), &cmd{cmd: cmdPeerHeaders, key: peer})
+	if err != nil {
+		return nil, err
+	}
+
+	headers, ok := out.payload.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("%s command: %w", cmdPeerHeaders, ErrInvalidReply)
+	}
+
+	return headers, nil
+}
+
+// withTimeout applies the default timeout to contexts without a deadline.
+func (g *Gyre) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+// deliver hands a command over to the node.
+func (g *Gyre) deliver(ctx context.Context, c *cmd) error {
+	// Don't race a context which is already done against the node
+	if ctx.Err() != nil {
+		return g.contextError(ctx, c)
+	}
+
 	select {
-	case g.cmds <- &cmd{cmd: cmdShout, key: group, payload: []byte(payload)}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %ss command", cmdShout)
+	case g.cmds <- c:
+		return nil
+	case <-ctx.Done():
+		return g.contextError(ctx, c)
 	}
-	return nil
 }
 
-// Dump prints Gyre node information.
-func (g *Gyre) Dump() error {
+// contextError explains why ctx gave up on the command.
+func (g *Gyre) contextError(ctx context.Context, c *cmd) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s command: %w", c.cmd, ErrNodeNotResponding)
+	}
+
+	return fmt.Errorf("%s command: %w", c.cmd, ctx.Err())
+}
+
+// send sends a command which the node doesn't reply to.
+func (g *Gyre) send(ctx context.Context, c *cmd) error {
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	return g.deliver(ctx, c)
+}
+
+// request sends a command to the node and waits for its reply.
+func (g *Gyre) request(ctx context.Context, c *cmd) (*reply, error) {
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	// The node never blocks on a buffered reply channel, even if we have
+	// already given up on it
+	c.replies = make(chan *reply, 1)
+
+	err := g.deliver(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
 	select {
-	case g.cmds <- &cmd{cmd: cmdDump}:
-	case <-time.After(timeout):
-		return fmt.Errorf("Node is not responding to %s command", cmdDump)
+	case out := <-c.replies:
+		return out, out.err
+	case <-ctx.Done():
+		return nil, g.contextError(ctx, c)
+	}
+}
+
+// requestString sends a command to the node and expects a string in reply.
+func (g *Gyre) requestString(ctx context.Context, c *cmd) (string, error) {
+	out, err := g.request(ctx, c)
+	if err != nil {
+		return "", err
 	}
-	return nil
+
+	str, ok := out.payload.(string)
+	if !ok {
+		return "", fmt.Errorf("%s command: %w", c.cmd, ErrInvalidReply)
+	}
+
+	return str, nil
+}
+
+// requestStrings sends a command to the node and expects a list of strings in reply.
+func (g *Gyre) requestStrings(ctx context.Context, c *cmd) ([]string, error) {
+	out, err := g.request(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := out.payload.([]string)
+	if !ok {
+		return nil, fmt.Errorf("%s command: %w", c.cmd, ErrInvalidReply)
+	}
+
+	return list, nil
 }
@@ -2,14 +2,25 @@ package gyre
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/zeromq/gyre/beacon"
 )
 
 const (
@@ -123,6 +134,727 @@ func TestJoinLeave(t *testing.T) {
 	}
 }
 
+func TestPeerIntrospection(t *testing.T) {
+	port := random(5660, 15670)
+	t.Logf("using port %d", port)
+	launchNodes(2, port, 1*time.Second)
+	defer stopNodes(2)
+
+	identity := nodes[1].identity()
+
+	peers, err := gyre[0].Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(peers, []string{identity}) {
+		t.Fatalf("expected %v but got %v", []string{identity}, peers)
+	}
+
+	peers, err = gyre[0].PeersByGroup("GLOBAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(peers, []string{identity}) {
+		t.Errorf("expected %v in GLOBAL but got %v", []string{identity}, peers)
+	}
+
+	groups, err := gyre[0].OwnGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"GLOBAL"}) {
+		t.Errorf("expected own groups %v but got %v", []string{"GLOBAL"}, groups)
+	}
+
+	groups, err = gyre[0].PeerGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"GLOBAL"}) {
+		t.Errorf("expected peer groups %v but got %v", []string{"GLOBAL"}, groups)
+	}
+
+	if name, err := gyre[0].PeerName(identity); err != nil {
+		t.Error(err)
+	} else if name != "node1" {
+		t.Errorf("expected node1 but got %s", name)
+	}
+
+	if addr, err := gyre[0].PeerAddress(identity); err != nil {
+		t.Error(err)
+	} else if addr == "" {
+		t.Error("PeerAddress() shouldn't return empty string")
+	}
+
+	if header, err := gyre[0].PeerHeader(identity, "X-HELLO-1"); err != nil {
+		t.Error(err)
+	} else if header != "World-1" {
+		t.Errorf("expected World-1 but got %s", header)
+	}
+
+	if h, err := gyre[0].PeerHeaders(identity); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(h, headers[1]) {
+		t.Errorf("expected %v but got %v", headers[1], h)
+	}
+
+	if _, err := gyre[0].PeerName("UNKNOWN"); err == nil {
+		t.Error("expected an error for an unknown peer")
+	}
+}
+
+func TestDump(t *testing.T) {
+	port := random(5660, 15670)
+	t.Logf("using port %d", port)
+	launchNodes(2, port, 1*time.Second)
+	defer stopNodes(2)
+
+	state, err := gyre[0].Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.UUID != nodes[0].identity() {
+		t.Errorf("expected uuid %s but got %s", nodes[0].identity(), state.UUID)
+	}
+	if state.Name != "node0" {
+		t.Errorf("expected node0 but got %s", state.Name)
+	}
+	if !reflect.DeepEqual(state.Headers, headers[0]) {
+		t.Errorf("expected %v but got %v", headers[0], state.Headers)
+	}
+	if !reflect.DeepEqual(state.OwnGroups, []string{"GLOBAL"}) {
+		t.Errorf("expected own groups %v but got %v", []string{"GLOBAL"}, state.OwnGroups)
+	}
+
+	if len(state.Peers) != 1 {
+		t.Fatalf("expected one peer but got %d", len(state.Peers))
+	}
+	peer := state.Peers[0]
+	if peer.UUID != nodes[1].identity() {
+		t.Errorf("expected peer %s but got %s", nodes[1].identity(), peer.UUID)
+	}
+	if !peer.Connected || !peer.Ready {
+		t.Errorf("expected peer to be connected and ready, got %v and %v", peer.Connected, peer.Ready)
+	}
+	if !reflect.DeepEqual(peer.Groups, []string{"GLOBAL"}) {
+		t.Errorf("expected peer groups %v but got %v", []string{"GLOBAL"}, peer.Groups)
+	}
+
+	if _, err := json.Marshal(state); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	g, _, err := newGyre()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetPort(random(5660, 15670))
+	g.SetInterface("lo")
+
+	if _, err := g.Addr(); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted but got %v", err)
+	}
+	if _, err := g.PeerName("UNKNOWN"); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("expected ErrPeerNotFound but got %v", err)
+	}
+	if _, err := g.PeerHeaders("UNKNOWN"); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("expected ErrPeerNotFound but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.JoinContext(ctx, "GLOBAL"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+
+	err = g.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// STOP is the last event
+	for done := false; !done; {
+		select {
+		case event := <-g.Events():
+			done = event.Type() == EventStop
+		case <-time.After(time.Second):
+			t.Fatal("expected to receive EventStop")
+		}
+	}
+
+	// Nobody is listening to the commands anymore
+	g.SetTimeout(50 * time.Millisecond)
+	if err := g.Join("GLOBAL"); !errors.Is(err, ErrNodeNotResponding) {
+		t.Errorf("expected ErrNodeNotResponding but got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := g.DumpContext(ctx); !errors.Is(err, ErrNodeNotResponding) {
+		t.Errorf("expected ErrNodeNotResponding but got %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	invalid := [][]Option{
+		{WithName("")},
+		{WithPort(70000)},
+		{WithEvasive(5 * time.Second), WithExpired(3 * time.Second)},
+		{WithLoopInterval(0)},
+		{WithEndpoint("no-transport")},
+		{WithPort(0)},
+		{WithEndpoint("tcp://127.0.0.1:5670"), WithBeaconVersion(2)},
+		{WithGossipConnect("tcp://127.0.0.1:5670"), WithBeaconSecret("secret")},
+	}
+	for _, opts := range invalid {
+		if _, err := New(opts...); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig but got %v", err)
+		}
+	}
+
+	// A configuration which can't be applied leaves no node behind, nor
+	// the gossip engine started for the endpoint
+	if g, err := New(WithEndpoint("tcp://127.0.0.1:99999")); err == nil || g != nil {
+		t.Errorf("expected an error and no node but got %v and %v", err, g)
+	}
+
+	g, n, err := newGyre(
+		WithName("node-with-options"),
+		WithPort(random(5660, 15670)),
+		WithInterface("lo"),
+		WithHeaders(map[string]string{"X-HELLO": "World"}),
+		WithEvasive(100*time.Millisecond),
+		WithExpired(200*time.Millisecond),
+		WithLoopInterval(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := g.Name(); name != "node-with-options" {
+		t.Errorf("expected node-with-options but got %s", name)
+	}
+	if h, err := g.Headers(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(h, map[string]string{"X-HELLO": "World"}) {
+		t.Errorf("expected %v but got %v", map[string]string{"X-HELLO": "World"}, h)
+	}
+	if n.evasive != 100*time.Millisecond || n.expired != 200*time.Millisecond || n.loopInterval != 50*time.Millisecond {
+		t.Errorf("peer timers weren't applied, got %s, %s and %s", n.evasive, n.expired, n.loopInterval)
+	}
+
+	err = g.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Stop()
+
+	// A port given along with the endpoint beacons the endpoint
+	port := random(5660, 15670)
+	endpoint := fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000))
+	g, n, err = newGyre(WithEndpoint(endpoint), WithPort(port), WithBeaconVersion(2), WithCluster("staging"), WithBeaconSecret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+	if n.beaconPort != port || n.endpoint != endpoint {
+		t.Errorf("expected to beacon %s on %d but got %s on %d", endpoint, port, n.endpoint, n.beaconPort)
+	}
+
+	// An empty secret switches shared secret mode off, as SetBeaconSecret
+	g, n, err = newGyre(WithPort(random(5660, 15670)), WithBeaconSecret("secret"), WithBeaconSecret(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+	if n.beaconSecret != nil {
+		t.Errorf("expected no beacon secret but got %q", n.beaconSecret)
+	}
+}
+
+func TestPeerTimers(t *testing.T) {
+	endpoints := []string{
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000)),
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(30000, 40000)),
+	}
+	g0, _, err := newGyre(WithPort(0), WithEndpoint(endpoints[0]), WithEvasive(time.Second), WithExpired(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g0.Stop()
+	g1, _, err := newGyre(WithPort(0), WithEndpoint(endpoints[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g1.Stop()
+
+	for _, g := range []*Gyre{g0, g1} {
+		err = g.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = g1.SetExpired(20 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g1.SetEvasive(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g1.SetLoopInterval(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The runtime setters keep the timers in order, as the options do
+	for _, err := range []error{g1.SetExpired(0), g1.SetEvasive(20 * time.Second), g1.SetExpired(10 * time.Second)} {
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig but got %v", err)
+		}
+	}
+
+	s0, err := g0.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := g1.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each node keeps its own policy
+	if s0.Evasive != time.Second || s0.Expired != 2*time.Second {
+		t.Errorf("expected 1s and 2s but got %s and %s", s0.Evasive, s0.Expired)
+	}
+	if s1.Evasive != 10*time.Second || s1.Expired != 20*time.Second || s1.LoopInterval != 100*time.Millisecond {
+		t.Errorf("expected 10s, 20s and 100ms but got %s, %s and %s", s1.Evasive, s1.Expired, s1.LoopInterval)
+	}
+
+	// And applies it to its peers
+	err = g1.RequirePeer(g0.UUID(), endpoints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-g1.Events():
+		if event.Type() != EventEnter {
+			t.Fatalf("expected EventEnter but got %s", event.Type())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("node1 hasn't seen node0")
+	}
+	s1, err = g1.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s1.Peers) != 1 {
+		t.Fatalf("expected one peer but got %d", len(s1.Peers))
+	}
+	if p := s1.Peers[0]; p.ExpiredAt.Sub(p.EvasiveAt) != 10*time.Second {
+		t.Errorf("expected node0 to expire 10s after being evasive, got %s", p.ExpiredAt.Sub(p.EvasiveAt))
+	}
+}
+
+func TestMultiFrame(t *testing.T) {
+	port := random(5660, 15670)
+	t.Logf("using port %d", port)
+	launchNodes(2, port, 1*time.Second)
+	defer stopNodes(2)
+
+	frames := [][]byte{[]byte("Hello"), []byte("World"), []byte("!")}
+
+	gyre[0].ShoutFrames("GLOBAL", frames)
+	gyre[0].WhisperFrames(nodes[1].identity(), frames)
+
+	for _, typ := range []EventType{EventShout, EventWhisper} {
+		timeout := time.After(time.Second)
+	loop:
+		for {
+			select {
+			case event := <-gyre[1].Events():
+				if event.Type() != typ {
+					continue
+				}
+				if !reflect.DeepEqual(event.Frames(), frames) {
+					t.Errorf("expected %q but got %q", frames, event.Frames())
+				}
+				if !bytes.Equal(event.Msg(), frames[0]) {
+					t.Errorf("expected %q but got %q", frames[0], event.Msg())
+				}
+				break loop
+			case <-timeout:
+				t.Fatalf("No %s has been received from node0", typ)
+			}
+		}
+	}
+}
+
+func TestCurve(t *testing.T) {
+	port := random(5660, 15670)
+	t.Logf("using port %d", port)
+
+	if _, err := New(WithCertificate("short", "keys")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig but got %v", err)
+	}
+
+	// Node 0 only lets in node 1, node 1 and 2 know node 0's key
+	pub := make([]string, 3)
+	sec := make([]string, 3)
+	for i := range pub {
+		var err error
+		pub[i], sec[i], err = zmq.NewCurveKeypair()
+		if err != nil {
+			t.Skipf("CURVE isn't available: %s", err)
+		}
+	}
+
+	g := make([]*Gyre, 3)
+	for i := range g {
+		opts := []Option{WithPort(port), WithInterface("lo"), WithCertificate(pub[i], sec[i])}
+		if i == 0 {
+			opts = append(opts, WithAuthenticator(AllowList(pub[1])))
+		}
+		var err error
+		g[i], _, err = newGyre(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer g[i].Stop()
+	}
+	for i := 1; i < 3; i++ {
+		err := g[i].SetPublicKey(g[0].UUID(), pub[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range g {
+		err := g[i].Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Give time for them to interconnect
+	time.Sleep(1500 * time.Millisecond)
+
+	if peers, err := g[0].Peers(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(peers, []string{g[1].UUID()}) {
+		t.Errorf("expected only %s to get in but got %v", g[1].UUID(), peers)
+	}
+	if key, err := g[1].PeerHeader(g[0].UUID(), "X-PUBLICKEY"); err != nil {
+		t.Error(err)
+	} else if key != pub[0] {
+		t.Errorf("expected %s but got %s", pub[0], key)
+	}
+	if state, err := g[2].PeerLifecycle(g[0].UUID()); err == nil && state == PeerReady {
+		t.Errorf("expected node 0 to keep node 2 out")
+	}
+}
+
+func TestZAPMalformed(t *testing.T) {
+	key := "Yne@$w-vo<fVvi]a<NY6T1ed:M$fCG*[IaLV{hID"
+	domain := fmt.Sprintf("zap-malformed-%d", rand.Int())
+	err := zapRegister(domain, AllowList(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zapUnregister(domain)
+
+	client, err := zmq.NewSocket(zmq.REQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetRcvtimeo(time.Second)
+	err = client.Connect(zapEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A malformed request is answered, so the handler keeps serving
+	client.SendMessage("1.0", "1")
+	if reply, err := client.RecvMessage(0); err != nil {
+		t.Fatal(err)
+	} else if len(reply) < 3 || reply[2] != "500" {
+		t.Errorf("expected status 500 but got %q", reply)
+	}
+
+	client.SendMessage("1.0", "2", domain, "127.0.0.1", "", "CURVE", zmq.Z85decode(key))
+	if reply, err := client.RecvMessage(0); err != nil {
+		t.Fatal(err)
+	} else if len(reply) < 5 || reply[2] != "200" || reply[4] != key {
+		t.Errorf("expected %s to be let in but got %q", key, reply)
+	}
+}
+
+func TestRequirePeer(t *testing.T) {
+	// Neither beacons nor gossip, the nodes only know of each other
+	// because they're told
+	endpoints := []string{
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000)),
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(30000, 40000)),
+	}
+	g := make([]*Gyre, 2)
+	for i := range g {
+		var err error
+		g[i], _, err = newGyre(WithPort(0), WithEndpoint(endpoints[i]), WithName("node"+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer g[i].Stop()
+	}
+
+	// A beaconing node doesn't know its endpoint until it's started
+	idle, _, err := newGyre(WithPort(random(5660, 15670)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Stop()
+	if err := idle.RequirePeer(g[1].UUID(), endpoints[1]); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("expected ErrNotStarted but got %v", err)
+	}
+
+	for i := range g {
+		err := g[i].Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := g[0].RequirePeer("not a uuid", endpoints[1]); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig but got %v", err)
+	}
+	err = g[0].RequirePeer(strings.ToLower(g[1].UUID()), endpoints[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handshake goes both ways
+	for i, node := range g {
+		select {
+		case event := <-node.Events():
+			if event.Type() != EventEnter {
+				t.Errorf("expected EventEnter but got %s", event.Type())
+			}
+			if event.Sender() != g[1-i].UUID() {
+				t.Errorf("expected %s but got %s", g[1-i].UUID(), event.Sender())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("node%d hasn't seen the other node", i)
+		}
+	}
+}
+
+func TestStaticPeers(t *testing.T) {
+	endpoints := []string{
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000)),
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(30000, 40000)),
+	}
+
+	// Both nodes share the same list, including themselves
+	f, err := ioutil.TempFile("", "gyre-static-peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "# Static peers\n%s\n\n%s\n", endpoints[0], endpoints[1])
+	f.Close()
+
+	if list, err := LoadStaticPeers(f.Name()); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(list, endpoints) {
+		t.Errorf("expected %v but got %v", endpoints, list)
+	}
+
+	g := make([]*Gyre, 2)
+	for i := range g {
+		g[i], _, err = newGyre(WithPort(0), WithEndpoint(endpoints[i]), WithStaticPeersFile(f.Name()), WithLoopInterval(100*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer g[i].Stop()
+	}
+
+	// The second node comes up late, the first one keeps trying
+	err = g[0].Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	err = g[1].Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, node := range g {
+		select {
+		case event := <-node.Events():
+			if event.Type() != EventEnter {
+				t.Errorf("expected EventEnter but got %s", event.Type())
+			}
+			if event.Sender() != g[1-i].UUID() {
+				t.Errorf("expected %s but got %s", g[1-i].UUID(), event.Sender())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("node%d hasn't seen the other node", i)
+		}
+	}
+
+	// Nodes don't greet themselves
+	for i, node := range g {
+		if peers, err := node.Peers(); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(peers, []string{g[1-i].UUID()}) {
+			t.Errorf("expected node%d to only know of %s but got %v", i, g[1-i].UUID(), peers)
+		}
+	}
+}
+
+func TestStaticPeerAt(t *testing.T) {
+	// The host name is resolved once, the endpoints it's matched against
+	// are taken as they are
+	sp := newStaticPeer("tcp://localhost:5670")
+	for endpoint, at := range map[string]bool{
+		"tcp://localhost:5670": true,
+		"tcp://127.0.0.1:5670": true,
+		"tcp://127.0.0.1:5671": false,
+		"tcp://10.0.0.1:5670":  false,
+		"ipc://localhost:5670": false,
+	} {
+		if sp.at(endpoint) != at {
+			t.Errorf("expected %s to be at %s: %v", endpoint, sp.endpoint, at)
+		}
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	// The nodes beacon through a hub rather than the network
+	hub := beacon.NewHub(1)
+	opts := make([][]Option, 3)
+	for i := range opts {
+		conn := hub.Join(net.IPv4(127, 0, 0, 1))
+		opts[i] = []Option{WithPort(zreDiscoveryPort), WithBeaconConn(conn), WithInterval(100 * time.Millisecond), WithName("node" + strconv.Itoa(i))}
+	}
+	g, stop := startNodes(t, opts...)
+
+	// All nodes contest, the one with the greatest UUID wins
+	for i := range g {
+		g[i].SetContestInGroup("GLOBAL")
+		g[i].Join("GLOBAL")
+	}
+
+	leader := func(nodes []*Gyre) (best int) {
+		for i := range nodes {
+			if nodes[i].UUID() > nodes[best].UUID() {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// waitLeader waits until node has been told about the given leader
+	waitLeader := func(node *Gyre, uuid string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-node.Events():
+				if event.Type() == EventLeader && event.Sender() == uuid {
+					if event.Group() != "GLOBAL" {
+						t.Errorf("expected GLOBAL but got %s", event.Group())
+					}
+					return
+				}
+			case <-timeout:
+				t.Fatalf("%s hasn't been told about leader %s", node.Name(), uuid)
+			}
+		}
+	}
+
+	best := leader(g)
+	for i := range g {
+		waitLeader(g[i], g[best].UUID())
+	}
+
+	// Once the leader has gone the others elect a new one
+	stop(best)
+	rest := append(append([]*Gyre{}, g[:best]...), g[best+1:]...)
+	best = leader(rest)
+	for i := range rest {
+		waitLeader(rest[i], rest[best].UUID())
+	}
+}
+
+func TestBeaconLeaving(t *testing.T) {
+	for _, secret := range []string{"", "secret"} {
+		hub := beacon.NewHub(1)
+		opts := make([][]Option, 2)
+		for i := range opts {
+			conn := hub.Join(net.IPv4(127, 0, 0, 1))
+			opts[i] = []Option{WithPort(zreDiscoveryPort), WithBeaconConn(conn), WithInterval(100 * time.Millisecond), WithBeaconSecret(secret)}
+
+			// Peers don't expire during the test, they can only leave
+			opts[i] = append(opts[i], WithEvasive(time.Minute), WithExpired(2*time.Minute))
+		}
+		g, stop := startNodes(t, opts...)
+
+		expectEvent(t, g[0], EventEnter, g[1].UUID(), time.Second)
+		stop(1)
+		expectEvent(t, g[0], EventExit, g[1].UUID(), 500*time.Millisecond)
+		stop(0)
+	}
+}
+
+func TestBeaconPartition(t *testing.T) {
+	hub := beacon.NewHub(1)
+	conns := make([]*beacon.HubConn, 3)
+	opts := make([][]Option, len(conns))
+	for i := range conns {
+		conns[i] = hub.Join(net.IPv4(127, 0, 0, 1))
+		opts[i] = []Option{WithPort(zreDiscoveryPort), WithBeaconConn(conns[i]), WithInterval(100 * time.Millisecond)}
+	}
+
+	// node0 is cut off from the others until the partition heals
+	hub.Partition(conns[:1], conns[1:])
+	g, _ := startNodes(t, opts...)
+
+	expectEvent(t, g[1], EventEnter, g[2].UUID(), time.Second)
+	expectEvent(t, g[2], EventEnter, g[1].UUID(), time.Second)
+	select {
+	case event := <-g[0].Events():
+		t.Errorf("expected node0 alone, got %s from %s", event.Type(), event.Sender())
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	hub.Heal()
+	entered := make(map[string]bool)
+	timeout := time.After(time.Second)
+	for len(entered) < len(g)-1 {
+		select {
+		case event := <-g[0].Events():
+			if event.Type() == EventEnter {
+				entered[event.Sender()] = true
+			}
+		case <-timeout:
+			t.Fatalf("node0 has seen %d of the other nodes once healed", len(entered))
+		}
+	}
+	for i := 1; i < len(g); i++ {
+		if !entered[g[i].UUID()] {
+			t.Errorf("expected node0 to see node%d", i)
+		}
+	}
+}
+
 func testTwoNodes(t *testing.T, port int, wait time.Duration) {
 	launchNodes(2, port, wait)
 	defer stopNodes(2)
@@ -211,7 +943,455 @@ func testSyncedHeaders(t *testing.T, n, port int, wait time.Duration) {
 	}
 }
 
+// memHub is an in-memory discovery network, each node announced on it is
+// sighted by the others
+type memHub struct {
+	sync.Mutex
+	members []*memDiscovery
+}
+
+type memDiscovery struct {
+	hub       *memHub
+	uuid      string
+	endpoint  string
+	sightings chan Sighting
+}
+
+func (h *memHub) discovery() *memDiscovery {
+	d := &memDiscovery{hub: h, sightings: make(chan Sighting, 10)}
+	h.Lock()
+	h.members = append(h.members, d)
+	h.Unlock()
+	return d
+}
+
+// broadcast passes the sighting on to the other members, dropping it for
+// the ones which aren't reading theirs
+func (h *memHub) broadcast(from *memDiscovery, s Sighting) {
+	h.Lock()
+	defer h.Unlock()
+	for _, d := range h.members {
+		if d == from {
+			continue
+		}
+		select {
+		case d.sightings <- s:
+		default:
+		}
+	}
+}
+
+func (d *memDiscovery) Start() error { return nil }
+
+func (d *memDiscovery) Stop() error {
+	d.hub.broadcast(d, Sighting{UUID: d.uuid})
+	return nil
+}
+
+func (d *memDiscovery) Announce(uuid, endpoint string) error {
+	d.uuid, d.endpoint = uuid, endpoint
+	d.hub.broadcast(d, Sighting{UUID: uuid, Endpoint: endpoint})
+	return nil
+}
+
+func (d *memDiscovery) Sightings() <-chan Sighting {
+	return d.sightings
+}
+
+// startNodes creates and starts a node for each set of options. The nodes
+// still running once the test is over are stopped, stop stops one of them
+// before that.
+func startNodes(t *testing.T, opts ...[]Option) (g []*Gyre, stop func(i int)) {
+	t.Helper()
+
+	running := make(map[int]bool)
+	t.Cleanup(func() {
+		for i := range running {
+			g[i].Stop()
+		}
+	})
+
+	g = make([]*Gyre, len(opts))
+	for i := range opts {
+		var err error
+		g[i], _, err = newGyre(opts[i]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		running[i] = true
+		err = g[i].Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop = func(i int) {
+		t.Helper()
+		if !running[i] {
+			return
+		}
+		delete(running, i)
+		if err := g[i].Stop(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	return g, stop
+}
+
+// expectEvent waits for an event of the given type, skipping the others, and
+// checks it's from the given sender
+func expectEvent(t *testing.T, g *Gyre, typ EventType, sender string, timeout time.Duration) *Event {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-g.Events():
+			if event.Type() != typ {
+				continue
+			}
+			if event.Sender() != sender {
+				t.Fatalf("expected %s from %s but got it from %s", typ, sender, event.Sender())
+			}
+			return event
+		case <-deadline:
+			t.Fatalf("expected %s from %s but got nothing", typ, sender)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	if _, err := New(WithPort(0), WithDiscovery(nil)); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig but got %v", err)
+	}
+
+	// The first node is announced before the second one listens, the
+	// second one's announcement brings them together
+	hub := &memHub{}
+	g, stop := startNodes(t,
+		[]Option{WithPort(0), WithEndpoint(fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000))), WithDiscovery(hub.discovery())},
+		[]Option{WithPort(0), WithEndpoint(fmt.Sprintf("tcp://127.0.0.1:%d", random(30000, 40000))), WithDiscovery(hub.discovery())},
+	)
+	if err := g[0].SetDiscovery(new(memHub).discovery()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig but got %v", err)
+	}
+
+	for i := range g {
+		expectEvent(t, g[i], EventEnter, g[1-i].UUID(), time.Second)
+	}
+
+	// The backend tells the first node the second one is going away,
+	// long before it would expire
+	stop(1)
+	expectEvent(t, g[0], EventExit, g[1].UUID(), time.Second)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gyre-discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A node which went away without cleaning up
+	stale := filepath.Join(dir, "0123456789ABCDEF0123456789ABCDEF")
+	err = ioutil.WriteFile(stale, []byte("ipc://gone\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(stale, old, old)
+
+	d := make([]*FileDiscovery, 2)
+	opts := make([][]Option, len(d))
+	for i := range d {
+		d[i] = NewFileDiscovery(dir).SetHeartbeat(100 * time.Millisecond)
+		endpoint := "ipc://" + filepath.Join(dir, fmt.Sprintf(".node%d.ipc", i))
+		opts[i] = []Option{WithPort(0), WithEndpoint(endpoint), WithDiscovery(d[i])}
+	}
+	g, stop := startNodes(t, opts...)
+
+	for i, node := range g {
+		expectEvent(t, node, EventEnter, g[1-i].UUID(), time.Second)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected the stale file to be removed but got %v", err)
+	}
+
+	// The file of a stopped node goes with it
+	stop(1)
+	if _, err := os.Stat(filepath.Join(dir, g[1].UUID())); !os.IsNotExist(err) {
+		t.Errorf("expected the file of node1 to be removed but got %v", err)
+	}
+	expectEvent(t, g[0], EventExit, g[1].UUID(), time.Second)
+
+	// Stopping the backend again is harmless
+	if err := d[1].Stop(); err != nil {
+		t.Errorf("expected a second Stop to succeed but got %v", err)
+	}
+}
+
+func TestGossipWithdrawal(t *testing.T) {
+	endpoints := []string{
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(20000, 30000)),
+		fmt.Sprintf("tcp://127.0.0.1:%d", random(30000, 40000)),
+	}
+	hub := fmt.Sprintf("inproc://gossip-withdrawal-%d", rand.Int())
+
+	// Peers don't expire during the test, they can only be withdrawn
+	opts := make([][]Option, len(endpoints))
+	for i := range opts {
+		opts[i] = []Option{WithPort(0), WithEndpoint(endpoints[i]), WithEvasive(time.Minute), WithExpired(2 * time.Minute)}
+		if i == 0 {
+			opts[i] = append(opts[i], WithGossipBind(hub))
+		} else {
+			opts[i] = append(opts[i], WithGossipConnect(hub))
+		}
+	}
+	g, stop := startNodes(t, opts...)
+
+	for i, node := range g {
+		expectEvent(t, node, EventEnter, g[1-i].UUID(), 2*time.Second)
+	}
+
+	if hubs, err := g[1].GossipHubs(); err != nil {
+		t.Error(err)
+	} else if len(hubs) != 1 || hubs[0].Endpoint != hub || !hubs[0].Connected {
+		t.Errorf("expected to be connected to %s but got %+v", hub, hubs)
+	}
+	if _, err := g[0].GossipHubs(); err != nil {
+		t.Error(err)
+	}
+
+	// node0 only learns node1 has gone from the withdrawn tuple
+	stop(1)
+	expectEvent(t, g[0], EventExit, g[1].UUID(), time.Second)
+}
+
 func random(min, max int) int {
 	rand.Seed(time.Now().Unix())
 	return rand.Intn(max-min) + min
 }
+
+func TestBeaconFamilies(t *testing.T) {
+	d := newBeaconDiscovery(nil, beaconConfig{interval: 100 * time.Millisecond})
+	peer := Sighting{UUID: "4E1B9B5C8E2A4C3B9F0D6A7E8C1B2D3F", Endpoint: "tcp://192.168.1.2:5670"}
+
+	// A dual stack peer is sighted in the family we heard it in first
+	if !d.first(peer, "192.168.1.2") {
+		t.Error("expected the first sighting to pass")
+	}
+	if d.first(peer, "fe80::2") {
+		t.Error("expected the sighting in the other family to be dropped")
+	}
+	if !d.first(peer, "192.168.1.2") {
+		t.Error("expected the sighting in the same family to pass")
+	}
+
+	// Until that family goes silent
+	time.Sleep(familySilence*100*time.Millisecond + 50*time.Millisecond)
+	if !d.first(peer, "fe80::2") {
+		t.Error("expected the other family to take over")
+	}
+
+	// Peers going away are always let through
+	if !d.first(Sighting{UUID: peer.UUID}, "192.168.1.2") {
+		t.Error("expected the departure to pass")
+	}
+}
+
+func TestBeaconVersions(t *testing.T) {
+	d := newBeaconDiscovery(beacon.New(), beaconConfig{})
+	d.uuid = []byte("0123456789ABCDEF")
+	uuid := fmt.Sprintf("%X", d.uuid)
+
+	// Version 1 tells the port, the address is where the beacon comes from
+	d.emit, d.endpoint, d.tcpPort = beaconV1, "tcp://10.0.0.1:5670", 5670
+	transmit := d.encode(false, time.Now())
+	if len(transmit) != 22 {
+		t.Errorf("expected a 22 bytes version 1 beacon, got %d bytes", len(transmit))
+	}
+	s, ok := d.decode(&beacon.Signal{Addr: "192.168.1.2", Transmit: transmit})
+	if !ok || s.UUID != uuid || s.Endpoint != "tcp://192.168.1.2:5670" {
+		t.Errorf("expected the endpoint from the source address, got %+v", s)
+	}
+
+	// Version 2 tells the endpoint
+	d.emit, d.endpoint = beaconV2, "ipc:///tmp/gyre-node"
+	s, ok = d.decode(&beacon.Signal{Addr: "192.168.1.2", Transmit: d.encode(false, time.Now())})
+	if !ok || s.UUID != uuid || s.Endpoint != "ipc:///tmp/gyre-node" {
+		t.Errorf("expected the endpoint from the beacon, got %+v", s)
+	}
+
+	// Later versions may add to the end of it
+	s, ok = d.decode(&beacon.Signal{Addr: "192.168.1.2", Transmit: append(d.encode(false, time.Now()), "more"...)})
+	if !ok || s.Endpoint != "ipc:///tmp/gyre-node" {
+		t.Errorf("expected the endpoint from the extended beacon, got %+v", s)
+	}
+
+	// Either version tells when the peer is going away
+	for _, version := range []byte{beaconV1, beaconV2} {
+		d.emit = version
+		s, ok = d.decode(&beacon.Signal{Addr: "192.168.1.2", Transmit: d.encode(true, time.Now())})
+		if !ok || s.UUID != uuid || s.Endpoint != "" {
+			t.Errorf("expected version %d beacon to tell the peer is going away, got %+v", version, s)
+		}
+	}
+
+	// Anything else is dropped
+	invalid := [][]byte{
+		[]byte("ZR"),
+		[]byte("XYZ\x01"),
+		append([]byte("ZRE\x03"), d.uuid...),
+		append([]byte("ZRE\x01"), d.uuid[:10]...),
+		append(append([]byte("ZRE\x02\x00"), d.uuid...), 10, 'i', 'p', 'c'),
+	}
+	for _, transmit := range invalid {
+		if s, ok := d.decode(&beacon.Signal{Addr: "192.168.1.2", Transmit: transmit}); ok {
+			t.Errorf("expected % X to be dropped, got %+v", transmit, s)
+		}
+	}
+}
+
+func TestClusterBeacons(t *testing.T) {
+	staging := newBeaconDiscovery(beacon.New(), beaconConfig{cluster: "staging"})
+	staging.uuid, staging.emit, staging.endpoint = []byte("0123456789ABCDEF"), beaconV2, "tcp://10.0.0.1:5670"
+	production := newBeaconDiscovery(beacon.New(), beaconConfig{cluster: "production"})
+	production.uuid, production.emit, production.endpoint = []byte("FEDCBA9876543210"), beaconV2, "tcp://10.0.0.2:5670"
+	none := newBeaconDiscovery(beacon.New(), beaconConfig{})
+	none.uuid, none.emit, none.tcpPort = []byte("0123456789012345"), beaconV1, 5670
+
+	// The beacon filters out the other clusters
+	if transmit := staging.encode(false, time.Now()); !bytes.HasPrefix(transmit, clusterFilter("staging")) {
+		t.Errorf("expected % X to start with the cluster filter % X", transmit, clusterFilter("staging"))
+	}
+	if transmit := production.encode(false, time.Now()); bytes.HasPrefix(transmit, clusterFilter("staging")) {
+		t.Errorf("expected % X not to pass the filter of another cluster", transmit)
+	}
+
+	// And the rest are dropped once parsed
+	for _, c := range []struct {
+		from, to *beaconDiscovery
+		ok       bool
+	}{
+		{staging, staging, true},
+		{production, staging, false},
+		{none, staging, false},
+		{staging, none, false},
+		{none, none, true},
+	} {
+		_, ok := c.to.decode(&beacon.Signal{Addr: "10.0.0.3", Transmit: c.from.encode(false, time.Now())})
+		if ok != c.ok {
+			t.Errorf("expected %q to take the beacon of %q: %v, got %v", c.to.cluster, c.from.cluster, c.ok, ok)
+		}
+	}
+
+	for _, c := range []struct{ cluster, uuid string }{{"", "ABCD"}, {"staging", "ABCD"}, {"a/b", "ABCD"}} {
+		if cluster, uuid := parseGossipKey(gossipKey(c.cluster, c.uuid)); cluster != c.cluster || uuid != c.uuid {
+			t.Errorf("expected %q and %q, got %q and %q", c.cluster, c.uuid, cluster, uuid)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	if _, err := New(WithCluster(strings.Repeat("x", 256))); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig but got %v", err)
+	}
+
+	// The discovery tells nothing about the cluster, the nodes find out
+	// from HELLO
+	hub := &memHub{}
+	clusters := []string{"staging", "production", "staging"}
+	d := make([]*memDiscovery, len(clusters))
+	opts := make([][]Option, len(clusters))
+	for i, cluster := range clusters {
+		d[i] = hub.discovery()
+		endpoint := fmt.Sprintf("tcp://127.0.0.1:%d", random(20000+i*10000, 30000+i*10000))
+		opts[i] = []Option{WithPort(0), WithEndpoint(endpoint), WithDiscovery(d[i]), WithCluster(cluster)}
+	}
+	g, _ := startNodes(t, opts...)
+
+	// Only the staging nodes get together
+	event := expectEvent(t, g[0], EventEnter, g[2].UUID(), time.Second)
+	if cluster, _ := event.Header("X-CLUSTER"); cluster != "staging" {
+		t.Errorf("expected staging but got %q", cluster)
+	}
+	select {
+	case event := <-g[1].Events():
+		t.Errorf("expected production node alone, got %s from %s", event.Type(), event.Sender())
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// The staging nodes aren't connected to again when they're sighted
+	// again, e.g. on the next heartbeat of the backend
+	for i := range g {
+		d[i].Announce(g[i].UUID(), d[i].endpoint)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s, err := g[1].Dump(); err != nil {
+		t.Error(err)
+	} else if len(s.Peers) != 0 {
+		t.Errorf("expected production node to ignore the rejected peers, got %v", s.Peers)
+	}
+}
+
+func TestBeaconSecret(t *testing.T) {
+	node := func(secret []byte, uuid string) *beaconDiscovery {
+		d := newBeaconDiscovery(beacon.New(), beaconConfig{secret: secret})
+		d.uuid, d.emit, d.endpoint, d.tcpPort = []byte(uuid), beaconV2, "tcp://10.0.0.1:5670", 5670
+		return d
+	}
+	a := node([]byte("secret"), "0123456789ABCDEF")
+	b := node([]byte("secret"), "FEDCBA9876543210")
+	other := node([]byte("other"), "0123456789012345")
+	plain := node(nil, "5432109876543210")
+
+	recv := func(d *beaconDiscovery, transmit []byte) (Sighting, bool) {
+		return d.decode(&beacon.Signal{Addr: "10.0.0.1", Transmit: transmit})
+	}
+
+	now := time.Now()
+	signed := a.encode(false, now)
+	if s, ok := recv(b, signed); !ok || s.Endpoint != "tcp://10.0.0.1:5670" {
+		t.Errorf("expected the signed beacon to pass, got %+v", s)
+	}
+	if _, ok := recv(b, signed); !ok {
+		t.Error("expected the same beacon to pass again, e.g. over another interface")
+	}
+	if _, ok := recv(plain, signed); !ok {
+		t.Error("expected nodes without secret to take signed beacons")
+	}
+
+	// Beacons which aren't signed with the secret are unauthenticated
+	tampered := append([]byte{}, signed...)
+	tampered[len(tampered)-beaconMACLen-9] ^= 1
+	plain.emit = beaconV1
+	for _, transmit := range [][]byte{other.encode(false, now), plain.encode(false, now), tampered} {
+		if s, ok := recv(b, transmit); ok {
+			t.Errorf("expected % X to be rejected, got %+v", transmit, s)
+		}
+	}
+
+	// Older beacons than the last one, and stale ones, are replayed
+	if _, ok := recv(b, a.encode(false, now.Add(-time.Second))); ok {
+		t.Error("expected an older beacon to be rejected")
+	}
+	if _, ok := recv(b, other.encode(false, now)); ok {
+		t.Error("expected a beacon signed with another secret to be rejected")
+	}
+	other.secret = b.secret
+	if _, ok := recv(b, other.encode(false, now.Add(-time.Minute))); ok {
+		t.Error("expected a stale beacon to be rejected")
+	}
+
+	// Going away must be signed as well
+	if s, ok := recv(b, a.encode(true, now.Add(time.Second))); !ok || s.Endpoint != "" {
+		t.Errorf("expected the signed departure to pass, got %+v", s)
+	}
+
+	want := BeaconRejections{Unauthenticated: 4, Replayed: 2}
+	if got := b.rejections(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
@@ -1,9 +1,8 @@
 package gyre
 
 import (
-	"bytes"
 	crand "crypto/rand"
-	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -11,61 +10,68 @@ import (
 	"math/rand"
 	"net"
 	"net/url"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/armen/goviral/zgossip"
 	zmq "github.com/pebbe/zmq4"
 	"github.com/zeromq/gyre/beacon"
+	"github.com/zeromq/gyre/zgossip"
 	"github.com/zeromq/gyre/zre/msg"
 )
 
 type node struct {
 	reactor       *zmq.Reactor
-	terminated    chan interface{}  // API shut us down
-	wg            sync.WaitGroup    // wait group is used to wait until actor() is done
-	events        chan *Event       // We send all Gyre events to the events channel
-	cmds          chan interface{}  // Receive commands from the cmds channel
-	replies       chan interface{}  // Send command replies to the replies channel
-	verbose       bool              // Log all traffic
-	beaconPort    int               // Beacon port number
-	interval      time.Duration     // Beacon interval
-	beacon        *beacon.Beacon    // Beacon object
-	uuid          []byte            // Our UUID
-	inbox         *zmq.Socket       // Our inbox socket (ROUTER)
-	name          string            // Our public name
-	endpoint      string            // Our public endpoint
-	port          uint16            // Our inbox port number
-	bound         bool              // Did app bind node explicitly?
-	status        byte              // Our own change counter
-	peers         map[string]*peer  // Hash of known peers, fast lookup
-	peerGroups    map[string]*group // Groups that our peers are in
-	ownGroups     map[string]*group // Groups that we are in
-	headers       map[string]string // Our header values
-	gossip        *zgossip.Zgossip  // Gossip discovery service, if any
-	gossipBind    string            // Gossip bind endpoint, if any
-	gossipConnect string            // Gossip connect endpoint, if any
-}
-
-// Beacon frame has this format:
-//
-// Z R E       3 bytes
-// Version     1 byte, %x01
-// UUID        16 bytes
-// Port        2 bytes in network order
-type aBeacon struct {
-	Protocol [3]byte
-	Version  byte
-	UUID     []byte
-	Port     uint16
+	terminated    chan interface{}       // API shut us down
+	wg            sync.WaitGroup         // wait group is used to wait until actor() is done
+	events        chan *Event            // We send all Gyre events to the events channel
+	cmds          chan interface{}       // Receive commands from the cmds channel
+	verbose       bool                   // Log all traffic
+	beaconPort    int                    // Beacon port number
+	interval      time.Duration          // Beacon interval
+	beaconVersion byte                   // Beacon version to emit, zero to negotiate
+	cluster       string                 // Cluster we belong to, if any
+	beaconSecret  []byte                 // Shared secret beacons are signed with, if any
+	beaconing     *beaconDiscovery       // Beacon backend, once started
+	evasive       time.Duration          // Silence after which a peer is evasive
+	expired       time.Duration          // Silence after which a peer is expired
+	loopInterval  time.Duration          // Interval of checking health of peers
+	ticker        *time.Ticker           // Ticks every loopInterval, once started
+	tickerID      uint64                 // Reactor id of the ticker channel
+	beacon        *beacon.Beacon         // Beacon object
+	uuid          []byte                 // Our UUID
+	inbox         *zmq.Socket            // Our inbox socket (ROUTER)
+	name          string                 // Our public name
+	endpoint      string                 // Our public endpoint
+	port          uint16                 // Our inbox port number
+	bound         bool                   // Did app bind node explicitly?
+	status        byte                   // Our own change counter
+	resyncs       uint64                 // How many times we've resynced with peers, see NodeState
+	lost          uint64                 // How many times we've lost messages, see NodeState
+	peers         map[string]*peer       // Hash of known peers, fast lookup
+	rejected      map[string]time.Time   // Peers from other clusters, until when we ignore them
+	peerGroups    map[string]*group      // Groups that our peers are in
+	ownGroups     map[string]*group      // Groups that we are in
+	contests      map[string]bool        // Groups we contest the leadership of
+	elections     map[string]*election   // Leader elections in our groups
+	leaders       map[string]string      // Leaders of our groups, once elected
+	headers       map[string]string      // Our header values
+	publicKey     string                 // Our CURVE public key, if any
+	secretKey     string                 // Our CURVE secret key, if any
+	peerKeys      map[string]string      // CURVE public keys of peers we know of
+	authenticator Authenticator          // Decides which keys may connect, if any
+	static        map[string]*staticPeer // Static peer list by endpoint
+	gossip        *zgossip.Gossip        // Gossip discovery service, if any
+	gossipBind    string                 // Gossip bind endpoint, if any
+	gossipConnect string                 // Gossip connect endpoint, if any
+	discoveries   []Discovery            // Discovery backends set by the application
+	started       []Discovery            // Discovery backends running
+	sightings     chan interface{}       // Peers sighted by the discovery backends
 }
 
 const (
-	beaconVersion = 0x1
-
 	// IANA-assigned port for ZRE discovery protocol
 	zreDiscoveryPort = 5670
 
@@ -76,20 +82,33 @@ const (
 )
 
 // newNode creates a new node.
-func newNode(events chan *Event, cmds chan interface{}, replies chan interface{}) (n *node, err error) {
+func newNode(events chan *Event, cmds chan interface{}) (n *node, err error) {
 	n = &node{
 		reactor:    zmq.NewReactor(),
 		events:     events,
 		cmds:       cmds,
-		replies:    replies,
 		beaconPort: zreDiscoveryPort,
 		peers:      make(map[string]*peer),
+		rejected:   make(map[string]time.Time),
 		peerGroups: make(map[string]*group),
 		ownGroups:  make(map[string]*group),
+		contests:   make(map[string]bool),
+		elections:  make(map[string]*election),
+		leaders:    make(map[string]string),
 		headers:    make(map[string]string),
+		peerKeys:   make(map[string]string),
+		static:     make(map[string]*staticPeer),
+		sightings:  make(chan interface{}, 100),
 		terminated: make(chan interface{}),
 	}
 
+	// Peer timers default to the package-wide settings
+	optMx.Lock()
+	n.evasive = peerEvasive
+	n.expired = peerExpired
+	n.loopInterval = loopInterval
+	optMx.Unlock()
+
 	n.beacon = beacon.New()
 
 	n.inbox, err = zmq.NewSocket(zmq.ROUTER)
@@ -124,123 +143,126 @@ func (n *node) gossipStart() (err error) {
 		}
 
 		if n.verbose {
-			n.gossip.SendCmd("VERBOSE", nil, 100*time.Millisecond)
+			n.gossip.SetVerbose(true)
 		}
 	}
 
 	return err
 }
 
-// Start node, return nil if OK, error if not possible
-func (n *node) start() (err error) {
-
-	// If application didn't bind explicitly, we grab an ephemeral port
-	// on all available network interfaces. This is orthogonal to
-	// beaconing, since we can connect to other peers and they will
-	// gossip our endpoint to others.
-	if !n.bound {
-		_, n.port, err = bind(n.inbox, "tcp://*:*")
-		if err != nil {
-			return err
-		}
-		n.bound = true
+// setFamily sets the IP families the beacon works on
+func (n *node) setFamily(family string) {
+	switch family {
+	case familyIPv4:
+		n.beacon.SetIPv4Only()
+	case familyIPv6:
+		n.beacon.SetIPv6Only()
+	case familyDual:
+		n.beacon.SetDualStack()
 	}
+}
 
-	// Start UDP beaconing, if the application didn't disable it
-	if n.beaconPort > 0 {
+// setEndpoint binds the inbox to the given endpoint and switches the node
+// to gossip discovery
+func (n *node) setEndpoint(endpoint string) (err error) {
+	err = n.gossipStart()
+	if err != nil {
+		return err
+	}
 
-		b := &aBeacon{}
-		b.Protocol[0] = 'Z'
-		b.Protocol[1] = 'R'
-		b.Protocol[2] = 'E'
-		b.Version = beaconVersion
-		b.UUID = n.uuid
-		b.Port = n.port
+	n.endpoint, _, err = bind(n.inbox, endpoint)
+	if err != nil {
+		return err
+	}
+	n.bound = true
+	n.beaconPort = 0
 
-		buffer := new(bytes.Buffer)
-		binary.Write(buffer, binary.BigEndian, b.Protocol)
-		binary.Write(buffer, binary.BigEndian, b.Version)
-		binary.Write(buffer, binary.BigEndian, b.UUID)
-		binary.Write(buffer, binary.BigEndian, b.Port)
+	return nil
+}
 
-		if n.interval > 0 {
-			n.beacon.SetInterval(n.interval)
-		}
+// setCertificate makes the inbox a CURVE server using the given certificate
+// and advertises the public key to the peers. It must be called before the
+// inbox is bound.
+func (n *node) setCertificate(publicKey, secretKey string) (err error) {
+	if n.bound {
+		return fmt.Errorf("%w: certificate must be set before the node is bound", ErrInvalidConfig)
+	}
+	if err = validKey(publicKey); err != nil {
+		return err
+	}
+	if err = validKey(secretKey); err != nil {
+		return err
+	}
 
-		n.beacon.SetPort(n.beaconPort)
-		n.beacon.NoEcho()
-		n.beacon.Subscribe([]byte("ZRE"))
-		err := n.beacon.Publish(buffer.Bytes())
-		if err != nil {
-			return err
-		}
+	err = n.inbox.ServerAuthCurve(n.zapDomain(), secretKey)
+	if err != nil {
+		return err
+	}
+	n.publicKey = publicKey
+	n.secretKey = secretKey
+	n.headers[headerPublicKey] = publicKey
 
-		// Our own host endpoint is provided by the beacon
-		if n.endpoint != "" {
-			panic("Endpoint is already set")
-		}
-		ip := net.ParseIP(n.beacon.Addr())
-		if ip.To4() == nil {
-			n.endpoint = fmt.Sprintf("tcp://[%s]:%d", ip.String(), n.port)
-		} else {
-			n.endpoint = fmt.Sprintf("tcp://%s:%d", ip.String(), n.port)
-		}
+	return nil
+}
 
-		n.reactor.AddChannel(n.beacon.Signals(), 1, func(s interface{}) error {
-			n.recvFromBeacon(s.(*beacon.Signal))
-			return nil
-		})
+// setAuthenticator sets the hook deciding which peers may connect to us,
+// nil lets in every peer.
+func (n *node) setAuthenticator(auth Authenticator) error {
+	n.authenticator = auth
+	if auth == nil {
+		zapUnregister(n.zapDomain())
+		return nil
+	}
 
-	} else {
+	return zapRegister(n.zapDomain(), auth)
+}
 
-		if n.endpoint == "" {
-			hostname, err := os.Hostname()
-			if err != nil {
-				return err
-			}
-			n.endpoint = fmt.Sprintf("tcp://%s:%d", hostname, n.port)
-		}
+// zapDomain is the ZAP domain connections to our inbox are authenticated in
+func (n *node) zapDomain() string {
+	return "gyre." + n.identity()
+}
 
-		if n.gossip == nil {
-			return errors.New("Gossip engine hasn't been started yet, use SetEndpoint, GossipBind or GossipConnect")
-		}
+// bindGossip binds the gossip engine to a well-known endpoint
+func (n *node) bindGossip(endpoint string) (err error) {
+	err = n.gossipStart()
+	if err != nil {
+		return err
+	}
 
-		n.gossip.SendCmd("PUBLISH", map[string]string{n.identity(): n.endpoint}, 100*time.Millisecond)
+	return n.gossip.Bind(endpoint)
+}
 
-		// Start polling on zgossip
-		n.reactor.AddChannel(n.gossip.Resp(), 1, func(r interface{}) error {
-			n.recvFromGossip(r)
-			return nil
-		})
+// connectGossip connects the gossip engine to another node's gossip endpoint
+func (n *node) connectGossip(endpoint string) (err error) {
+	err = n.gossipStart()
+	if err != nil {
+		return err
 	}
 
-	return
+	return n.gossip.Connect(endpoint)
 }
 
-// Stop node discovery and interconnection
-func (n *node) stop() {
-
-	if n.beacon != nil {
-		// Stop broadcast/listen beacon
-		b := &aBeacon{}
-		b.Protocol[0] = 'Z'
-		b.Protocol[1] = 'R'
-		b.Protocol[2] = 'E'
-		b.Version = beaconVersion
-		b.UUID = n.uuid
-		b.Port = 0 // Zero means we're stopping
+// Start node, return nil if OK, error if not possible
+func (n *node) start() (err error) {
 
-		buffer := new(bytes.Buffer)
-		binary.Write(buffer, binary.BigEndian, b.Protocol)
-		binary.Write(buffer, binary.BigEndian, b.Version)
-		binary.Write(buffer, binary.BigEndian, b.UUID)
-		binary.Write(buffer, binary.BigEndian, b.Port)
+	// If application didn't bind explicitly, we grab an ephemeral port
+	// on all available network interfaces. This is orthogonal to
+	// beaconing, since we can connect to other peers and they will
+	// gossip our endpoint to others.
+	if !n.bound {
+		_, n.port, err = bind(n.inbox, "tcp://*:*")
+		if err != nil {
+			return err
+		}
+		n.bound = true
+	}
 
-		n.beacon.Publish(buffer.Bytes())
-		time.Sleep(1 * time.Millisecond) // Allow 1 msec for beacon to go out
+	return n.startDiscovery()
+}
 
-		n.beacon.Close()
-	}
+// Stop node discovery and interconnection
+func (n *node) stop() {
+	n.stopDiscovery()
 }
 
 // recvFromAPI handles a new command received from front-end
@@ -252,10 +274,10 @@ func (n *node) recvFromAPI(c *cmd) {
 
 	switch c.cmd {
 	case cmdUUID:
-		n.replies <- &reply{cmd: cmdUUID, payload: n.identity()}
+		n.reply(c, &reply{cmd: cmdUUID, payload: n.identity()})
 
 	case cmdName:
-		n.replies <- &reply{cmd: cmdName, payload: n.name}
+		n.reply(c, &reply{cmd: cmdName, payload: n.name})
 
 	case cmdSetName:
 		n.name = c.payload.(string)
@@ -275,77 +297,172 @@ func (n *node) recvFromAPI(c *cmd) {
 		n.interval = c.payload.(time.Duration)
 
 	case cmdSetIface:
-		n.beacon.SetInterface(c.payload.(string))
+		n.beacon.SetInterface(c.payload.([]string)...)
 
-	case cmdSetEndpoint:
-		err := n.gossipStart()
-		if err != nil {
-			// Signal the caller and send back the error if any
-			n.replies <- &reply{cmd: cmdSetEndpoint, err: err}
+	case cmdSetFamily:
+		n.setFamily(c.payload.(string))
+
+	case cmdSetCluster:
+		err := n.setCluster(c.payload.(string))
+		n.reply(c, &reply{cmd: cmdSetCluster, err: err})
+
+	case cmdSetBeaconSecret:
+		if n.started != nil {
+			n.reply(c, &reply{cmd: cmdSetBeaconSecret, err: fmt.Errorf("%w: beacon secret must be set before the node is started", ErrInvalidConfig)})
 			break
 		}
+		n.beaconSecret = c.payload.([]byte)
+		n.reply(c, &reply{cmd: cmdSetBeaconSecret})
 
-		endpoint := c.payload.(string)
-		n.endpoint, _, err = bind(n.inbox, endpoint)
-		if err != nil {
-			n.replies <- &reply{cmd: cmdSetEndpoint, err: err}
+	case cmdSetBeaconConn:
+		if n.started != nil {
+			n.reply(c, &reply{cmd: cmdSetBeaconConn, err: fmt.Errorf("%w: beacon connections must be set before the node is started", ErrInvalidConfig)})
 			break
 		}
-		n.bound = true
-		n.beaconPort = 0
+		n.beacon.SetPacketConn(c.payload.([]beacon.PacketConn)...)
+		n.reply(c, &reply{cmd: cmdSetBeaconConn})
 
-		n.replies <- &reply{cmd: cmdSetEndpoint}
+	case cmdBeaconRejections:
+		var rejections BeaconRejections
+		if n.beaconing != nil {
+			rejections = n.beaconing.rejections()
+		}
+		n.reply(c, &reply{cmd: cmdBeaconRejections, payload: rejections})
 
-	case cmdGossipBind:
-		err := n.gossipStart()
-		if err != nil {
-			n.replies <- &reply{cmd: cmdGossipBind, err: err}
+	case cmdSetBeaconVersion:
+		version := c.payload.(int)
+		if version < 0 || version > beaconV2 {
+			n.reply(c, &reply{cmd: cmdSetBeaconVersion, err: fmt.Errorf("%w: unknown beacon version %d", ErrInvalidConfig, version)})
 			break
 		}
+		n.beaconVersion = byte(version)
+		n.reply(c, &reply{cmd: cmdSetBeaconVersion})
 
-		endpoint := c.payload.(string)
-		err = n.gossip.SendCmd("BIND", endpoint, 5*time.Second)
-		n.replies <- &reply{cmd: cmdGossipBind, err: err}
+	case cmdSetEndpoint:
+		// Signal the caller and send back the error if any
+		err := n.setEndpoint(c.payload.(string))
+		n.reply(c, &reply{cmd: cmdSetEndpoint, err: err})
+
+	case cmdSetDiscovery:
+		err := n.setDiscovery(c.payload.([]Discovery))
+		n.reply(c, &reply{cmd: cmdSetDiscovery, err: err})
+
+	case cmdGossipBind:
+		err := n.bindGossip(c.payload.(string))
+		n.reply(c, &reply{cmd: cmdGossipBind, err: err})
 
 	case cmdGossipPort:
-		err := n.gossip.SendCmd("PORT", nil, 5*time.Second)
+		if n.gossip == nil {
+			n.reply(c, &reply{cmd: cmdGossipPort, err: fmt.Errorf("gossip: %w", ErrNotStarted)})
+			break
+		}
+		port, err := n.gossip.Port()
 		if err != nil {
-			n.replies <- &reply{cmd: cmdGossipPort, err: err}
+			n.reply(c, &reply{cmd: cmdGossipPort, err: err})
 			break
 		}
-		port, err := n.gossip.RecvResp(5 * time.Second)
+		if port == 0 {
+			n.reply(c, &reply{cmd: cmdGossipPort, err: fmt.Errorf("gossip isn't bound to a TCP port: %w", ErrNotStarted)})
+			break
+		}
+		n.reply(c, &reply{cmd: cmdGossipPort, payload: strconv.FormatUint(uint64(port), 10)})
+
+	case cmdGossipHubs:
+		if n.gossip == nil {
+			n.reply(c, &reply{cmd: cmdGossipHubs, err: fmt.Errorf("gossip: %w", ErrNotStarted)})
+			break
+		}
+		remotes, err := n.gossip.Remotes()
 		if err != nil {
-			n.replies <- &reply{cmd: cmdGossipPort, err: err}
+			n.reply(c, &reply{cmd: cmdGossipHubs, err: err})
 			break
 		}
-		n.replies <- &reply{cmd: cmdGossipPort, payload: strconv.FormatUint(uint64(port.(uint16)), 10)}
+		hubs := make([]GossipHubState, len(remotes))
+		for i, r := range remotes {
+			hubs[i] = GossipHubState{
+				Endpoint:  r.Endpoint,
+				Connected: r.Connected,
+				LastHeard: r.LastHeard,
+				Failures:  r.Failures,
+				RetryAt:   r.RetryAt,
+			}
+		}
+		n.reply(c, &reply{cmd: cmdGossipHubs, payload: hubs})
 
 	case cmdGossipConnect:
-		err := n.gossipStart()
-		if err != nil {
-			n.replies <- &reply{cmd: cmdGossipConnect, err: err}
+		err := n.connectGossip(c.payload.(string))
+		n.reply(c, &reply{cmd: cmdGossipConnect, err: err})
+
+	case cmdSetCertificate:
+		err := n.setCertificate(c.key, c.payload.(string))
+		n.reply(c, &reply{cmd: cmdSetCertificate, err: err})
+
+	case cmdSetPublicKey:
+		key := c.payload.(string)
+		err := validKey(key)
+		if err == nil {
+			n.peerKeys[c.key] = key
+		}
+		n.reply(c, &reply{cmd: cmdSetPublicKey, err: err})
+
+	case cmdSetAuthenticator:
+		auth, _ := c.payload.(Authenticator)
+		err := n.setAuthenticator(auth)
+		n.reply(c, &reply{cmd: cmdSetAuthenticator, err: err})
+
+	case cmdSetStaticPeers:
+		err := n.setStaticPeers(c.payload.([]string))
+		n.reply(c, &reply{cmd: cmdSetStaticPeers, err: err})
+
+	case cmdSetEvasive:
+		evasive := c.payload.(time.Duration)
+		if evasive <= 0 {
+			n.reply(c, &reply{cmd: cmdSetEvasive, err: fmt.Errorf("%w: evasive timeout must be positive", ErrInvalidConfig)})
 			break
 		}
+		if evasive >= n.expired {
+			n.reply(c, &reply{cmd: cmdSetEvasive, err: fmt.Errorf("%w: evasive timeout (%s) must be shorter than expired timeout (%s)", ErrInvalidConfig, evasive, n.expired)})
+			break
+		}
+		n.evasive = evasive
+		n.reply(c, &reply{cmd: cmdSetEvasive})
 
-		endpoint := c.payload.(string)
-		err = n.gossip.SendCmd("CONNECT", endpoint, 5*time.Second)
-		n.replies <- &reply{cmd: cmdGossipConnect, err: err}
+	case cmdSetExpired:
+		expired := c.payload.(time.Duration)
+		if expired <= 0 {
+			n.reply(c, &reply{cmd: cmdSetExpired, err: fmt.Errorf("%w: expired timeout must be positive", ErrInvalidConfig)})
+			break
+		}
+		if n.evasive >= expired {
+			n.reply(c, &reply{cmd: cmdSetExpired, err: fmt.Errorf("%w: evasive timeout (%s) must be shorter than expired timeout (%s)", ErrInvalidConfig, n.evasive, expired)})
+			break
+		}
+		n.expired = expired
+		n.reply(c, &reply{cmd: cmdSetExpired})
+
+	case cmdSetLoopInterval:
+		interval := c.payload.(time.Duration)
+		if interval <= 0 {
+			n.reply(c, &reply{cmd: cmdSetLoopInterval, err: fmt.Errorf("%w: loop interval must be positive", ErrInvalidConfig)})
+			break
+		}
+		n.loopInterval = interval
+		if n.ticker != nil {
+			n.startTicker()
+		}
+		n.reply(c, &reply{cmd: cmdSetLoopInterval})
 
 	case cmdStart:
-		// Add the ping ticker just right before start so that it reads the latest
-		// value of loopInterval
-		n.reactor.AddChannelTime(time.Tick(loopInterval), 1, func(interface{}) error {
-			n.ping()
-			return nil
-		})
+		n.startTicker()
 
 		err := n.start()
 		// Signal the caller and send back the error if any
-		n.replies <- &reply{cmd: cmdStart, err: err}
+		n.reply(c, &reply{cmd: cmdStart, err: err})
 
 	case cmdStop, cmdTerm:
 		if n.terminated != nil {
 			close(n.terminated)
+			n.terminated = nil
 		}
 
 		// Wait and send the signal in a separate go routine
@@ -353,7 +470,7 @@ func (n *node) recvFromAPI(c *cmd) {
 		go func() {
 			n.wg.Wait()
 			// Signal the caller
-			n.replies <- &reply{}
+			n.reply(c, &reply{})
 		}()
 
 	case cmdWhisper:
@@ -364,7 +481,7 @@ func (n *node) recvFromAPI(c *cmd) {
 		// if peer doesn't exist (may have been destroyed)
 		if ok {
 			m := msg.NewWhisper()
-			m.Content = c.payload.([]byte)
+			m.Content = c.payload.([][]byte)
 			peer.send(m)
 		}
 
@@ -374,7 +491,7 @@ func (n *node) recvFromAPI(c *cmd) {
 		if g, ok := n.peerGroups[group]; ok {
 			m := msg.NewShout()
 			m.Group = group
-			m.Content = c.payload.([]byte)
+			m.Content = c.payload.([][]byte)
 			g.send(m)
 		}
 
@@ -395,6 +512,7 @@ func (n *node) recvFromAPI(c *cmd) {
 				cloned := msg.Clone(m)
 				peer.send(cloned)
 			}
+			n.startElection(group)
 		}
 
 	case cmdLeave:
@@ -413,27 +531,148 @@ func (n *node) recvFromAPI(c *cmd) {
 				peer.send(cloned)
 			}
 			delete(n.ownGroups, group)
+			delete(n.elections, group)
+			delete(n.leaders, group)
+		}
+
+	case cmdSetContest:
+		n.contests[c.key] = true
+		n.startElection(c.key)
+
+	case cmdRequirePeer:
+		identity, err := parseIdentity(c.key)
+		if err == nil {
+			err = validEndpoint(c.payload.(string))
+		}
+		if err == nil && n.endpoint == "" {
+			err = fmt.Errorf("%s command: %w", c.cmd, ErrNotStarted)
 		}
+		if err == nil && identity == n.identity() {
+			err = fmt.Errorf("%w: can't require ourselves", ErrInvalidConfig)
+		}
+		if err == nil {
+			_, err = n.requirePeer(identity, c.payload.(string))
+		}
+		n.reply(c, &reply{cmd: cmdRequirePeer, err: err})
 
 	case cmdDump:
-		// TODO: implement DUMP
+		state := n.state()
+		if n.verbose {
+			log.Printf("[%s] %s", n.name, state)
+		}
+		n.reply(c, &reply{cmd: cmdDump, payload: state})
+
+	case cmdPeers:
+		peers := make([]string, 0, len(n.peers))
+		for identity := range n.peers {
+			peers = append(peers, identity)
+		}
+		sort.Strings(peers)
+		n.reply(c, &reply{cmd: cmdPeers, payload: peers})
+
+	case cmdPeersByGroup:
+		peers := []string{}
+		if group, ok := n.peerGroups[c.key]; ok {
+			for identity := range group.peers {
+				peers = append(peers, identity)
+			}
+		}
+		sort.Strings(peers)
+		n.reply(c, &reply{cmd: cmdPeersByGroup, payload: peers})
+
+	case cmdPeerGroups:
+		groups := make([]string, 0, len(n.peerGroups))
+		for name, group := range n.peerGroups {
+			// Groups are kept around after the last peer has left
+			if len(group.peers) > 0 {
+				groups = append(groups, name)
+			}
+		}
+		sort.Strings(groups)
+		n.reply(c, &reply{cmd: cmdPeerGroups, payload: groups})
+
+	case cmdOwnGroups:
+		groups := make([]string, 0, len(n.ownGroups))
+		for name := range n.ownGroups {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		n.reply(c, &reply{cmd: cmdOwnGroups, payload: groups})
+
+	case cmdPeerName:
+		peer, ok := n.peers[c.key]
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerName, err: fmt.Errorf("%w: %s", ErrPeerNotFound, c.key)})
+			break
+		}
+		n.reply(c, &reply{cmd: cmdPeerName, payload: peer.name})
+
+	case cmdPeerState:
+		peer, ok := n.peers[c.key]
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerState, err: fmt.Errorf("%w: %s", ErrPeerNotFound, c.key)})
+			break
+		}
+		n.reply(c, &reply{cmd: cmdPeerState, payload: peer.state})
+
+	case cmdPeerAddress:
+		peer, ok := n.peers[c.key]
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerAddress, err: fmt.Errorf("%w: %s", ErrPeerNotFound, c.key)})
+			break
+		}
+		n.reply(c, &reply{cmd: cmdPeerAddress, payload: peer.endpoint})
+
+	case cmdPeerHeader:
+		peer, ok := n.peers[c.key]
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerHeader, err: fmt.Errorf("%w: %s", ErrPeerNotFound, c.key)})
+			break
+		}
+		header, ok := peer.Header(c.payload.(string))
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerHeader, err: fmt.Errorf("%w: %s", ErrHeaderNotFound, c.payload)})
+			break
+		}
+		n.reply(c, &reply{cmd: cmdPeerHeader, payload: header})
+
+	case cmdPeerHeaders:
+		peer, ok := n.peers[c.key]
+		if !ok {
+			n.reply(c, &reply{cmd: cmdPeerHeaders, err: fmt.Errorf("%w: %s", ErrPeerNotFound, c.key)})
+			break
+		}
+		// Hand out a copy, the peer's map is owned by the actor
+		headers := make(map[string]string, len(peer.headers))
+		for key, val := range peer.headers {
+			headers[key] = val
+		}
+		n.reply(c, &reply{cmd: cmdPeerHeaders, payload: headers})
 
 	case cmdAddr:
 		if n.beaconPort > 0 {
-			n.replies <- &reply{cmd: cmdAddr, payload: n.beacon.Addr()}
+			if n.beacon.Addr() == "" {
+				n.reply(c, &reply{cmd: cmdAddr, err: ErrNotStarted})
+				return
+			}
+			n.reply(c, &reply{cmd: cmdAddr, payload: n.beacon.Addr()})
 		} else {
+			if n.endpoint == "" {
+				n.reply(c, &reply{cmd: cmdAddr, err: ErrNotStarted})
+				return
+			}
 			u, err := url.Parse(n.endpoint)
 			if err != nil {
-				n.replies <- &reply{cmd: cmdHeader, err: err}
+				n.reply(c, &reply{cmd: cmdAddr, err: err})
 				return
 			}
 			ip, _, err := net.SplitHostPort(u.Host)
 			if err != nil {
-				n.replies <- &reply{cmd: cmdHeader, err: err}
+				n.reply(c, &reply{cmd: cmdAddr, err: err})
 				return
 			}
 
-			n.replies <- &reply{cmd: cmdAddr, payload: ip}
+			n.reply(c, &reply{cmd: cmdAddr, payload: ip})
 		}
 
 	case cmdHeader:
@@ -441,27 +680,70 @@ func (n *node) recvFromAPI(c *cmd) {
 
 		var err error
 		if !ok {
-			err = errors.New("Header doesn't exist")
+			err = fmt.Errorf("%w: %s", ErrHeaderNotFound, c.key)
 		}
 
-		n.replies <- &reply{cmd: cmdHeader, err: err, payload: header}
+		n.reply(c, &reply{cmd: cmdHeader, err: err, payload: header})
 
 	case cmdHeaders:
-		n.replies <- &reply{cmd: cmdHeader, payload: n.headers}
+		headers := make(map[string]string, len(n.headers))
+		for key, val := range n.headers {
+			headers[key] = val
+		}
+		n.reply(c, &reply{cmd: cmdHeaders, payload: headers})
 
 	default:
 		log.Printf("Invalid command %q %#v", c.cmd, c)
 	}
 }
 
+// reply sends back the reply of a command, if the caller is waiting for one.
+func (n *node) reply(c *cmd, r *reply) {
+	if c.replies != nil {
+		c.replies <- r
+	}
+}
+
 func (n *node) identity() string {
 	return fmt.Sprintf("%X", n.uuid)
 }
 
+// parseIdentity checks that uuid is a UUID string and returns it in the form
+// peers are identified by.
+func parseIdentity(uuid string) (string, error) {
+	b, err := hex.DecodeString(uuid)
+	if err != nil || len(b) != 16 {
+		return "", fmt.Errorf("%w: invalid UUID %q", ErrInvalidConfig, uuid)
+	}
+
+	return fmt.Sprintf("%X", b), nil
+}
+
 // requirePeer finds or creates peer via its UUID string
 func (n *node) requirePeer(identity string, endpoint string) (peer *peer, err error) {
+	return n.requirePeerVia(identity, endpoint, "")
+}
+
+// requirePeerVia finds or creates peer via its UUID string, a new peer is
+// greeted with the given local endpoint rather than ours if it's set
+func (n *node) requirePeerVia(identity, endpoint, local string) (peer *peer, err error) {
 	peer, ok := n.peers[identity]
 	if !ok {
+		if n.isRejected(identity) {
+			return nil, fmt.Errorf("peer %s is from another cluster", identity)
+		}
+
+		// We may be greeting the peer already, as a static peer
+		if peer = n.adoptStatic(identity, endpoint); peer != nil {
+			return peer, nil
+		}
+
+		// With CURVE we can only talk to peers whose key we know
+		serverKey, known := n.peerKeys[identity]
+		if n.secretKey != "" && !known {
+			return nil, fmt.Errorf("no CURVE public key for peer %s", identity)
+		}
+
 		// Purge any previous peer on same endpoint
 		for _, p := range n.peers {
 			if p.endpoint == endpoint {
@@ -470,23 +752,21 @@ func (n *node) requirePeer(identity string, endpoint string) (peer *peer, err er
 		}
 
 		peer = newPeer(identity)
+		if n.secretKey != "" {
+			peer.setCurve(serverKey, n.publicKey, n.secretKey)
+		}
 		err = peer.connect(n.uuid, endpoint)
 		if err != nil {
 			return nil, err
 		}
+		peer.refresh(n.evasive, n.expired)
 
 		// Handshake discovery by sending HELLO as first message
-		m := msg.NewHello()
-		m.Endpoint = n.endpoint
-		m.Status = n.status
-		m.Name = n.name
-		for key := range n.ownGroups {
-			m.Groups = append(m.Groups, key)
-		}
-		for key, header := range n.headers {
-			m.Headers[key] = header
+		hello := n.hello()
+		if local != "" {
+			hello.Endpoint = local
 		}
-		peer.send(m)
+		peer.send(hello)
 		n.peers[identity] = peer
 
 		// TODO(armen): Send new peer event to logger, if any
@@ -513,7 +793,11 @@ func (n *node) removePeer(peer *peer) {
 
 	// Remove peer from any groups we've got it in
 	for _, group := range n.peerGroups {
+		_, member := group.peers[peer.identity]
 		group.leave(peer)
+		if member {
+			n.electionPeerLeft(group.name, peer.identity)
+		}
 	}
 
 	// It's really important to disconnect from the peer before
@@ -523,6 +807,22 @@ func (n *node) removePeer(peer *peer) {
 	delete(n.peers, peer.identity)
 }
 
+// hello returns the HELLO message we greet peers with
+func (n *node) hello() *msg.Hello {
+	m := msg.NewHello()
+	m.Endpoint = n.endpoint
+	m.Status = n.status
+	m.Name = n.name
+	for key := range n.ownGroups {
+		m.Groups = append(m.Groups, key)
+	}
+	for key, header := range n.headers {
+		m.Headers[key] = header
+	}
+
+	return m
+}
+
 // requirePeerGroup finds or creates group via its name
 func (n *node) requirePeerGroup(name string) *group {
 	group, ok := n.peerGroups[name]
@@ -548,6 +848,8 @@ func (n *node) joinPeerGroup(peer *peer, name string) *group {
 		}
 	}
 
+	n.electionPeerJoined(name, peer)
+
 	return group
 }
 
@@ -565,11 +867,14 @@ func (n *node) leavePeerGroup(peer *peer, name string) *group {
 		}
 	}
 
+	n.electionPeerLeft(name, peer.identity)
+
 	return group
 }
 
-// recvFromPeer handles messages coming from other peers
-func (n *node) recvFromPeer(transit msg.Transit) {
+// recvFromPeer handles messages coming from other peers, clientKey is the
+// CURVE key the sender has authenticated with, if we know it
+func (n *node) recvFromPeer(transit msg.Transit, clientKey string) {
 	if transit == nil {
 		// Invalid transit
 		return
@@ -605,34 +910,80 @@ func (n *node) recvFromPeer(transit msg.Transit) {
 	case *msg.Hello:
 		// On HELLO we may create the peer if it's unknown
 		// On other cmds the peer must already exist
+		if identity == n.identity() {
+			// We've greeted ourselves through the static peer list
+			n.adoptStatic(identity, m.Endpoint)
+			return
+		}
+		// Peers from other clusters don't get in, see SetCluster
+		if cluster := m.Headers[headerCluster]; cluster != n.cluster {
+			if n.verbose {
+				log.Printf("[%s] Rejecting %s from cluster %q", n.name, identity, cluster)
+			}
+			if peer != nil && peer.ready() {
+				n.removePeer(peer)
+			} else if peer != nil {
+				peer.disconnect()
+				delete(n.peers, identity)
+			}
+			n.rejectPeer(identity)
+			return
+		}
 		if peer != nil {
-			// Remove fake peers
-			if peer.ready {
+			// Remove fake peers, and the ones we've been disconnected from
+			if peer.state != PeerConnecting {
 				n.removePeer(peer)
 			} else if n.endpoint == peer.endpoint {
 				// We ignore HELLO, if peer has same endpoint as current node
 				return
 			}
 		}
+		// Learn the peer's CURVE key from HELLO as long as it's the one
+		// the peer has authenticated with, anyone can say HELLO with
+		// someone else's key otherwise. We're only told that key when an
+		// Authenticator is set; keys set by the application take
+		// precedence.
+		if key := m.Headers[headerPublicKey]; key != "" && n.secretKey != "" {
+			if _, ok := n.peerKeys[identity]; !ok && key == clientKey {
+				n.peerKeys[identity] = key
+			} else if !ok && n.verbose {
+				log.Printf("[%s] Not learning the CURVE key of %s, it hasn't authenticated with it", n.name, identity)
+			}
+		}
 		var err error
 		peer, err = n.requirePeer(identity, m.Endpoint)
 		if err == nil {
-			peer.ready = true
+			peer.state = PeerReady
 		} else if n.verbose {
 			log.Printf("[%s] %s", n.name, err)
 		}
 	}
 
-	// Ignore command if peer isn't ready
-	if peer == nil || !peer.ready {
-		if peer != nil {
-			n.removePeer(peer)
+	// Ignore command if peer isn't ready. A peer we've just sent HELLO to
+	// may still have traffic in flight from before, it's kept around until
+	// it answers with its own HELLO or expires.
+	if peer == nil || !peer.ready() {
+		if peer != nil && n.verbose {
+			log.Printf("[%s] Ignoring %T from %s before HELLO", n.name, transit, peer.name)
 		}
 		return
 	}
 
 	if !peer.checkMessage(transit) {
-		log.Printf("[%s] lost messages from %s", n.name, identity)
+		// A gap in the sequence means the peer is broken, there's no way
+		// to get the messages back; tell the caller and reconnect
+		log.Printf("[%s] lost messages from %s, expected sequence %d but got %d", n.name, identity, peer.wantSequence+1, transit.Sequence())
+		n.lost++
+
+		select {
+		case n.events <- &Event{eventType: EventLost, sender: peer.identity, name: peer.name}:
+		default:
+			if n.verbose {
+				log.Printf("[%s] Dropping event: %s", n.name, EventLost)
+			}
+		}
+
+		n.resyncPeer(peer)
 		return
 	}
 
@@ -677,7 +1028,7 @@ func (n *node) recvFromPeer(transit msg.Transit) {
 	case *msg.Whisper:
 		// Pass up to caller API as WHISPER event
 		select {
-		case n.events <- &Event{eventType: EventWhisper, sender: identity, name: peer.name, msg: m.Content}:
+		case n.events <- newContentEvent(EventWhisper, identity, peer.name, "", m.Content):
 		default:
 			if n.verbose {
 				log.Printf("[%s] Dropping event: %s", n.name, EventWhisper)
@@ -687,7 +1038,7 @@ func (n *node) recvFromPeer(transit msg.Transit) {
 	case *msg.Shout:
 		// Pass up to caller as SHOUT event
 		select {
-		case n.events <- &Event{eventType: EventShout, sender: identity, name: peer.name, group: m.Group, msg: m.Content}:
+		case n.events <- newContentEvent(EventShout, identity, peer.name, m.Group, m.Content):
 		default:
 			if n.verbose {
 				log.Printf("[%s] Dropping event: %s", n.name, EventShout)
@@ -698,102 +1049,119 @@ func (n *node) recvFromPeer(transit msg.Transit) {
 		ping := msg.NewPingOk()
 		peer.send(ping)
 
+	case *msg.PingOk:
+		// Peer has answered our ping, it's alive again; this happens
+		// below for any kind of traffic
+
 	case *msg.Join:
-		n.joinPeerGroup(peer, m.Group)
-		if m.Status != peer.status {
-			panic(fmt.Sprintf("[%X] message status isn't equal to peer status, %d != %d", n.uuid, m.Status, peer.status))
+		// JOIN and LEAVE bump the peer's status, check it before applying
+		// them so a peer which is out of sync doesn't join and exit at once
+		if m.Status != peer.status+1 {
+			log.Printf("[%s] %s's group status is out of sync, %d != %d", n.name, peer.name, m.Status, peer.status+1)
+			n.resyncPeer(peer)
+			return
 		}
+		n.joinPeerGroup(peer, m.Group)
 
 	case *msg.Leave:
-		n.leavePeerGroup(peer, m.Group)
-		if m.Status != peer.status {
-			panic(fmt.Sprintf("[%X] message status isn't equal to peer status, %d != %d", n.uuid, m.Status, peer.status))
+		if m.Status != peer.status+1 {
+			log.Printf("[%s] %s's group status is out of sync, %d != %d", n.name, peer.name, m.Status, peer.status+1)
+			n.resyncPeer(peer)
+			return
 		}
+		n.leavePeerGroup(peer, m.Group)
+
+	case *msg.Elect:
+		n.recvElect(peer, m)
+
+	case *msg.Leader:
+		n.recvLeader(peer, m)
 	}
 
 	// Activity from peer resets peer timers
-	peer.refresh()
+	n.refreshPeer(peer)
 }
 
-// recvFromBeacon handles a new signal received from beacon
-func (n *node) recvFromBeacon(s *beacon.Signal) {
+// resyncPeer drops a peer we've lost track of and handshakes with it
+// again. Our HELLO makes the peer replace us with a fresh peer on its side,
+// which sends its own HELLO back; that HELLO's group list is authoritative.
+func (n *node) resyncPeer(peer *peer) {
+	n.resyncs++
 
-	b := &aBeacon{}
-	buffer := bytes.NewBuffer(s.Transmit)
-	binary.Read(buffer, binary.BigEndian, &b.Protocol)
-	binary.Read(buffer, binary.BigEndian, &b.Version)
+	// Removing the peer disconnects it, which forgets the endpoint
+	identity, endpoint := peer.identity, peer.endpoint
+	n.removePeer(peer)
 
-	uuid := make([]byte, 16)
-	binary.Read(buffer, binary.BigEndian, uuid)
-	b.UUID = append(b.UUID, uuid...)
-
-	binary.Read(buffer, binary.BigEndian, &b.Port)
-
-	// Ignore anything that isn't a valid beacon
-	if b.Version == beaconVersion {
-		// Check that the peer, identified by its UUID, exists
-		identity := fmt.Sprintf("%X", b.UUID)
-
-		if b.Port != 0 {
-			var endpoint string
-			// s.Addr is IP address of peer beacon
-			ip := net.ParseIP(s.Addr)
-			if ip.To4() == nil {
-				endpoint = fmt.Sprintf("tcp://[%s]:%d", ip.String(), b.Port)
-			} else {
-				endpoint = fmt.Sprintf("tcp://%s:%d", ip.String(), b.Port)
-			}
-			peer, err := n.requirePeer(identity, endpoint)
-			if err == nil {
-				peer.refresh()
-			} else if n.verbose {
-				log.Printf("[%s] %s", n.name, err)
-			}
-		} else {
-			// Zero port means peer is going away; remove it if
-			// we had any knowledge of it already
-			peer := n.peers[identity]
-			n.removePeer(peer)
-		}
-	} else if n.verbose {
-		log.Printf("[%s] Received a beacon with invalid version number %d", n.name, b.Version)
+	_, err := n.requirePeer(identity, endpoint)
+	if err != nil {
+		log.Printf("[%s] Unable to resync with %s: %s", n.name, identity, err)
 	}
 }
 
-// recvFromGossip handles a new response received from gossip
-func (n *node) recvFromGossip(r interface{}) {
-
-	resp := r.(*zgossip.Resp)
+// refreshPeer resets the peer timers and brings an evasive or silent peer
+// back to ready
+func (n *node) refreshPeer(peer *peer) {
+	peer.refresh(n.evasive, n.expired)
 
-	if n.verbose {
-		log.Printf("[%s] recvFromGossip: %#v", n.name, resp.Payload.(map[string]string))
+	if peer.state != PeerEvasive && peer.state != PeerSilent {
+		return
 	}
+	peer.state = PeerReady
 
-	for identity, endpoint := range resp.Payload.(map[string]string) {
-		if endpoint != n.endpoint {
-			peer, err := n.requirePeer(identity, endpoint)
-			if err == nil {
-				peer.refresh()
-			} else if n.verbose {
-				log.Printf("[%s] %s", n.name, err)
-			}
+	select {
+	case n.events <- &Event{eventType: EventAlive, sender: peer.identity, name: peer.name}:
+	default:
+		if n.verbose {
+			log.Printf("[%s] Dropping event: %s", n.name, EventAlive)
 		}
 	}
 }
 
 // We do this once a second:
-// - if peer has gone quiet, send TCP ping
+// - if peer has gone quiet, send TCP ping and tell the caller it's evasive
+// - if peer is still quiet, tell the caller it's silent
 // - if peer has disappeared, expire it
 func (n *node) pingPeer(peer *peer) {
-	if time.Now().Unix() >= peer.expiredAt.Unix() {
+	now := time.Now()
+
+	if !now.Before(peer.expiredAt) {
 		n.removePeer(peer)
-	} else if time.Now().Unix() >= peer.evasiveAt.Unix() {
-		// If peer is being evasive, force a TCP ping.
-		// TODO(armen): do this only once for a peer in this state;
-		// it would be nicer to use a proper state machine
-		// for peer management.
+		return
+	}
+
+	// The caller doesn't know about the peer until it's ready
+	if peer.state == PeerConnecting {
+		return
+	}
+
+	if peer.state == PeerReady && !now.Before(peer.evasiveAt) {
+		peer.state = PeerEvasive
+
+		// If peer is being evasive, force a TCP ping, only once
+		// per evasive episode
 		m := msg.NewPing()
 		peer.send(m)
+
+		select {
+		case n.events <- &Event{eventType: EventEvasive, sender: peer.identity, name: peer.name}:
+		default:
+			if n.verbose {
+				log.Printf("[%s] Dropping event: %s", n.name, EventEvasive)
+			}
+		}
+	}
+
+	if peer.state == PeerEvasive && !now.Before(peer.silentAt) {
+		peer.state = PeerSilent
+
+		// Peer hasn't answered our ping, tell the caller
+		select {
+		case n.events <- &Event{eventType: EventSilent, sender: peer.identity, name: peer.name}:
+		default:
+			if n.verbose {
+				log.Printf("[%s] Dropping event: %s", n.name, EventSilent)
+			}
+		}
 	}
 }
 
@@ -807,6 +1175,21 @@ func (n *node) terminate() {
 		peer.disconnect()
 		delete(n.peers, peerID)
 	}
+	if n.ticker != nil {
+		n.ticker.Stop()
+	}
+	if n.authenticator != nil {
+		zapUnregister(n.zapDomain())
+	}
+	for _, sp := range n.static {
+		if sp.probe != nil {
+			sp.probe.destroy()
+		}
+	}
+	if n.gossip != nil {
+		n.gossip.Close()
+	}
+
 	// Now it's safe to close the socket
 	n.inbox.Unbind(fmt.Sprintf("tcp://*:%d", n.port))
 	n.inbox.Close()
@@ -823,6 +1206,15 @@ func (n *node) actor() {
 		n.stop()
 		n.terminate()
 
+		// Let the caller know there won't be any more events
+		select {
+		case n.events <- &Event{eventType: EventStop, sender: n.identity(), name: n.name}:
+		default:
+			if n.verbose {
+				log.Printf("[%s] Dropping event: %s", n.name, EventStop)
+			}
+		}
+
 		return errors.New("terminate")
 	})
 
@@ -832,16 +1224,22 @@ func (n *node) actor() {
 		return nil
 	})
 
+	// Peers sighted by the discovery backends, once started
+	n.reactor.AddChannel(n.sightings, 1, func(s interface{}) error {
+		n.recvSighting(s.(Sighting))
+		return nil
+	})
+
 	// Handle the inbox
 	n.reactor.AddSocket(n.inbox, zmq.POLLIN, func(s zmq.State) error {
-		transit, err := msg.Recv(n.inbox)
+		transit, clientKey, err := n.recvInbox()
 		if err != nil {
 			if n.verbose {
 				log.Printf("[%s] %s", n.name, err)
 			}
 			return nil
 		}
-		n.recvFromPeer(transit)
+		n.recvFromPeer(transit, clientKey)
 
 		return nil
 	})
@@ -849,6 +1247,45 @@ func (n *node) actor() {
 	n.reactor.Run(10 * time.Millisecond)
 }
 
+// recvInbox receives a message from a peer along with the CURVE key the
+// peer has authenticated with, which our ZAP handler hands over as the
+// User-Id of the connection
+func (n *node) recvInbox() (msg.Transit, string, error) {
+	frames, metadata, err := n.inbox.RecvMessageBytesWithMetadata(0, "User-Id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The router socket puts the routing id first
+	if len(frames) <= 1 {
+		return nil, "", errors.New("no routingID")
+	}
+	transit, err := msg.Unmarshal(frames[1:]...)
+	if err != nil {
+		return nil, "", err
+	}
+	transit.SetRoutingID(frames[0])
+
+	return transit, metadata["User-Id"], nil
+}
+
+// startTicker (re)starts pinging the peers every loopInterval
+func (n *node) startTicker() {
+	if n.ticker != nil {
+		n.reactor.RemoveChannel(n.tickerID)
+		n.ticker.Stop()
+	}
+
+	n.ticker = time.NewTicker(n.loopInterval)
+	n.tickerID = n.reactor.AddChannelTime(n.ticker.C, 1, func(interface{}) error {
+		n.ping()
+		n.checkStatic()
+		n.checkElections()
+		n.checkRejected()
+		return nil
+	})
+}
+
 func (n *node) ping() {
 	if n.verbose && len(n.peers) == 0 {
 		log.Printf("[%s] There is no peer to ping", n.name)
@@ -868,7 +1305,8 @@ func bind(sock *zmq.Socket, endpoint string) (string, uint16, error) {
 		return endpoint, 0, err
 	}
 
-	if e.Scheme == "inproc" {
+	// Endpoints without a port are bound as they are
+	if e.Scheme == "inproc" || e.Scheme == "ipc" {
 		err = sock.Bind(endpoint)
 		return endpoint, 0, err
 	}
@@ -0,0 +1,326 @@
+package gyre
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/zeromq/gyre/zre/msg"
+)
+
+// newTestNode creates a node which isn't started, for the tests driving it
+// by hand; it's terminated once the test is done
+func newTestNode(t *testing.T) (*node, chan *Event) {
+	t.Helper()
+
+	events := make(chan *Event, 10)
+	n, err := newNode(events, make(chan interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.terminate)
+
+	return n, events
+}
+
+// newFakePeer makes up the UUID of a peer, along with its identity
+func newFakePeer() (you []byte, identity string) {
+	you = make([]byte, 16)
+	io.ReadFull(crand.Reader, you)
+	return you, fmt.Sprintf("%X", you)
+}
+
+// fakePeerMsg makes m look like it came from the peer with the given UUID,
+// with the given sequence number
+func fakePeerMsg(m msg.Transit, you []byte, seq uint16) {
+	m.SetRoutingID(append([]byte{1}, you...))
+	m.SetSequence(seq)
+}
+
+// expectNodeEvents checks that the node has emitted the given events, and
+// no more
+func expectNodeEvents(t *testing.T, events chan *Event, types ...EventType) {
+	t.Helper()
+
+	for _, typ := range types {
+		select {
+		case event := <-events:
+			if event.Type() != typ {
+				t.Fatalf("expected %s but got %s", typ, event.Type())
+			}
+		default:
+			t.Fatalf("expected %s but got nothing", typ)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected no more events but got %s", event.Type())
+	default:
+	}
+}
+
+func TestPingPeer(t *testing.T) {
+	n, events := newTestNode(t)
+
+	now := time.Now()
+	peer := newPeer("PEER")
+	peer.state = PeerReady
+	peer.evasiveAt = now.Add(-time.Millisecond)
+	peer.silentAt = now.Add(time.Hour)
+	peer.expiredAt = now.Add(2 * time.Hour)
+	n.peers[peer.identity] = peer
+
+	expectState := func(state PeerLifecycle) {
+		if peer.state != state {
+			t.Fatalf("expected peer to be %s but it's %s", state, peer.state)
+		}
+	}
+
+	// Evasive is reported once per transition
+	n.pingPeer(peer)
+	expectNodeEvents(t, events, EventEvasive)
+	expectState(PeerEvasive)
+	n.pingPeer(peer)
+	expectNodeEvents(t, events)
+
+	peer.silentAt = now.Add(-time.Millisecond)
+	n.pingPeer(peer)
+	expectNodeEvents(t, events, EventSilent)
+	expectState(PeerSilent)
+	n.pingPeer(peer)
+	expectNodeEvents(t, events)
+
+	// Hearing from the peer starts over
+	n.evasive, n.expired = time.Hour, 2*time.Hour
+	n.refreshPeer(peer)
+	expectNodeEvents(t, events, EventAlive)
+	expectState(PeerReady)
+	n.pingPeer(peer)
+	expectNodeEvents(t, events)
+
+	peer.expiredAt = now.Add(-time.Millisecond)
+	n.pingPeer(peer)
+	expectNodeEvents(t, events, EventExit)
+	expectState(PeerExpired)
+	if _, ok := n.peers[peer.identity]; ok {
+		t.Error("expected expired peer to be removed")
+	}
+}
+
+func TestPingConnectingPeer(t *testing.T) {
+	n, events := newTestNode(t)
+
+	// A peer which hasn't said HELLO yet is never reported as evasive
+	peer := newPeer("PEER")
+	peer.state = PeerConnecting
+	peer.evasiveAt = time.Now().Add(-time.Millisecond)
+	peer.silentAt = time.Now().Add(-time.Millisecond)
+	peer.expiredAt = time.Now().Add(time.Hour)
+	n.peers[peer.identity] = peer
+
+	n.pingPeer(peer)
+	expectNodeEvents(t, events)
+	if peer.state != PeerConnecting {
+		t.Errorf("expected peer to be %s but it's %s", PeerConnecting, peer.state)
+	}
+}
+
+func TestResyncPeer(t *testing.T) {
+	n, events := newTestNode(t)
+	you, identity := newFakePeer()
+
+	peer, err := n.requirePeer(identity, "tcp://127.0.0.1:5553")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.state = PeerReady
+
+	// JOIN carries a status the peer can't have
+	m := msg.NewJoin()
+	fakePeerMsg(m, you, 1)
+	m.Group = "GLOBAL"
+	m.Status = peer.status + 5
+
+	n.recvFromPeer(m, "")
+
+	if state := n.state(); state.Resyncs != 1 {
+		t.Errorf("expected one resync but got %d", state.Resyncs)
+	}
+
+	// The JOIN isn't applied, the peer just goes
+	expectNodeEvents(t, events, EventExit)
+	if _, ok := n.peerGroups["GLOBAL"]; ok {
+		t.Error("expected the peer not to join GLOBAL")
+	}
+
+	// The peer is back, waiting for its HELLO
+	resynced, ok := n.peers[identity]
+	if !ok {
+		t.Fatal("expected peer to be required again")
+	}
+	if resynced == peer || resynced.ready() || resynced.state != PeerConnecting {
+		t.Errorf("expected a fresh connecting peer, got %s", resynced.state)
+	}
+	if resynced.endpoint != "tcp://127.0.0.1:5553" {
+		t.Errorf("expected peer to reconnect to tcp://127.0.0.1:5553 but got %s", resynced.endpoint)
+	}
+
+	// A JOIN in sync is applied
+	fakePeerMsg(m, you, 1)
+	resynced.state = PeerReady
+	m.Status = resynced.status + 1
+	n.recvFromPeer(m, "")
+	if _, ok := n.peerGroups["GLOBAL"].peers[identity]; !ok {
+		t.Error("expected the peer to join GLOBAL")
+	}
+	if state := n.state(); state.Resyncs != 1 {
+		t.Errorf("expected no more resyncs but got %d", state.Resyncs)
+	}
+}
+
+func TestLostMessages(t *testing.T) {
+	n, events := newTestNode(t)
+	you, identity := newFakePeer()
+
+	peer, err := n.requirePeer(identity, "tcp://127.0.0.1:5554")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.state = PeerReady
+
+	// Sequence 1 and 2 never arrived
+	m := msg.NewWhisper()
+	fakePeerMsg(m, you, 3)
+	m.Content = [][]byte{[]byte("Hello")}
+
+	n.recvFromPeer(m, "")
+
+	if state := n.state(); state.Lost != 1 || state.Resyncs != 1 {
+		t.Errorf("expected one lost and one resync but got %d and %d", state.Lost, state.Resyncs)
+	}
+
+	expectNodeEvents(t, events, EventLost, EventExit)
+
+	// Traffic is ignored until the peer says HELLO again
+	resynced, ok := n.peers[identity]
+	if !ok {
+		t.Fatal("expected peer to be required again")
+	}
+	fakePeerMsg(m, you, 4)
+	n.recvFromPeer(m, "")
+	expectNodeEvents(t, events)
+	if resynced.state != PeerConnecting {
+		t.Errorf("expected peer to be %s but it's %s", PeerConnecting, resynced.state)
+	}
+}
+
+func TestLearnPeerKey(t *testing.T) {
+	n, _ := newTestNode(t)
+
+	// Keys of the examples of the ZeroMQ CURVE docs
+	n.publicKey = "rq:rM>}U?@Lns47E1%kR.o@n%FcmmsL/@{H8]yf7"
+	n.secretKey = "JTKVSB%%)wK0E.X)V>+}o?pNmC{O&4W4b!Ni{Lh6"
+	key := "Yne@$w-vo<fVvi]a<NY6T1ed:M$fCG*[IaLV{hID"
+	you, identity := newFakePeer()
+
+	hello := func() *msg.Hello {
+		m := msg.NewHello()
+		fakePeerMsg(m, you, 1)
+		m.Endpoint = "tcp://127.0.0.1:5555"
+		m.Headers[headerPublicKey] = key
+		return m
+	}
+
+	// A key isn't learnt unless the peer has authenticated with it, e.g.
+	// someone saying HELLO with the key of a peer we don't know yet
+	for _, clientKey := range []string{"", n.publicKey} {
+		n.recvFromPeer(hello(), clientKey)
+		if learnt, ok := n.peerKeys[identity]; ok {
+			t.Fatalf("expected no key to be learnt but got %s", learnt)
+		}
+	}
+
+	n.recvFromPeer(hello(), key)
+	if learnt := n.peerKeys[identity]; learnt != key {
+		t.Errorf("expected %s to be learnt but got %q", key, learnt)
+	}
+}
+
+func TestRejectedPeer(t *testing.T) {
+	n, _ := newTestNode(t)
+	n.cluster = "staging"
+	you, identity := newFakePeer()
+	endpoint := "tcp://127.0.0.1:5555"
+
+	// A peer sighted by a backend which can't tell the cluster says HELLO
+	// from another one
+	n.recvSighting(Sighting{UUID: identity, Endpoint: endpoint})
+	if _, ok := n.peers[identity]; !ok {
+		t.Fatal("expected the sighted peer to be created")
+	}
+	m := msg.NewHello()
+	fakePeerMsg(m, you, 1)
+	m.Endpoint = endpoint
+	m.Headers[headerCluster] = "production"
+	n.recvFromPeer(m, "")
+	if _, ok := n.peers[identity]; ok {
+		t.Fatal("expected the peer from another cluster to be removed")
+	}
+
+	// It isn't connected to again on the next sightings
+	n.recvSighting(Sighting{UUID: identity, Endpoint: endpoint})
+	if _, ok := n.peers[identity]; ok {
+		t.Error("expected the rejected peer to be ignored")
+	}
+	if _, err := n.requirePeer(identity, endpoint); err == nil {
+		t.Error("expected the rejected peer not to be required")
+	}
+
+	// Until the rejection expires
+	n.rejected[identity] = time.Now().Add(-time.Second)
+	n.checkRejected()
+	if _, ok := n.rejected[identity]; ok {
+		t.Error("expected the expired rejection to be forgotten")
+	}
+	n.recvSighting(Sighting{UUID: identity, Endpoint: endpoint})
+	if _, ok := n.peers[identity]; !ok {
+		t.Error("expected the peer to be connected to again")
+	}
+}
+
+func TestPruneLeft(t *testing.T) {
+	n, _ := newTestNode(t)
+
+	g := newGroup("GLOBAL")
+	n.peerGroups[g.name] = g
+	peer := newPeer("PEER")
+	n.peers[peer.identity] = peer
+	g.join(peer)
+	g.leave(peer)
+	if !n.hasLeft(g.name, peer.identity) {
+		t.Fatal("expected the peer to have left")
+	}
+
+	// The peer is remembered while it's around, a wave or the leadership
+	// names it, or its challenges may still be on their way
+	long := time.Now().Add(-time.Hour)
+	g.left[peer.identity] = long
+	n.checkElections()
+	delete(n.peers, peer.identity)
+	g.left[peer.identity] = time.Now()
+	n.checkElections()
+	g.left[peer.identity] = long
+	n.leaders[g.name] = peer.identity
+	n.checkElections()
+	if !n.hasLeft(g.name, peer.identity) {
+		t.Fatal("expected the peer to be remembered")
+	}
+
+	delete(n.leaders, g.name)
+	n.checkElections()
+	if n.hasLeft(g.name, peer.identity) {
+		t.Error("expected the peer to be forgotten")
+	}
+}
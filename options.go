@@ -0,0 +1,490 @@
+package gyre
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/zeromq/gyre/beacon"
+)
+
+// Option configures a Gyre node, see New.
+type Option func(*config) error
+
+// config collects the options passed to New so the whole configuration can
+// be validated before it's applied to the node.
+type config struct {
+	name          string
+	port          int
+	portSet       bool // Whether port was given, it then outlasts the endpoint
+	ifaces        []string
+	family        string
+	interval      time.Duration
+	beaconVersion byte
+	cluster       string
+	beaconSecret  []byte
+	beaconConns   []beacon.PacketConn
+	headers       map[string]string
+	verbose       bool
+	endpoint      string
+	gossipBind    string
+	gossipConnect []string
+	evasive       time.Duration
+	expired       time.Duration
+	loopInterval  time.Duration
+	timeout       time.Duration
+	publicKey     string
+	secretKey     string
+	peerKeys      map[string]string
+	authenticator Authenticator
+	staticPeers   []string
+	discoveries   []Discovery
+}
+
+// WithName sets node name; this is provided to other nodes during discovery.
+func WithName(name string) Option {
+	return func(c *config) error {
+		if name == "" {
+			return fmt.Errorf("%w: empty name", ErrInvalidConfig)
+		}
+		c.name = name
+		return nil
+	}
+}
+
+// WithPort sets ZRE discovery port, see SetPort.
+func WithPort(port int) Option {
+	return func(c *config) error {
+		if port < 0 || port > 0xffff {
+			return fmt.Errorf("%w: invalid port %d", ErrInvalidConfig, port)
+		}
+		c.port = port
+		c.portSet = true
+		return nil
+	}
+}
+
+// WithInterface sets the network interfaces to use for beacons and
+// interconnects, see SetInterface.
+func WithInterface(ifaces ...string) Option {
+	return func(c *config) error {
+		for _, iface := range ifaces {
+			if _, err := net.InterfaceByName(iface); err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+			}
+		}
+		c.ifaces = ifaces
+		return nil
+	}
+}
+
+// WithIPv4Only makes the beacon work on IPv4 alone, see SetIPv4Only.
+func WithIPv4Only() Option {
+	return func(c *config) error {
+		c.family = familyIPv4
+		return nil
+	}
+}
+
+// WithIPv6Only makes the beacon work on IPv6 alone, see SetIPv6Only.
+func WithIPv6Only() Option {
+	return func(c *config) error {
+		c.family = familyIPv6
+		return nil
+	}
+}
+
+// WithDualStack makes the beacon work on IPv4 and IPv6 at once, see
+// SetDualStack.
+func WithDualStack() Option {
+	return func(c *config) error {
+		c.family = familyDual
+		return nil
+	}
+}
+
+// WithInterval sets ZRE discovery interval, see SetInterval.
+func WithInterval(interval time.Duration) Option {
+	return func(c *config) error {
+		if interval < 0 {
+			return fmt.Errorf("%w: negative beacon interval", ErrInvalidConfig)
+		}
+		c.interval = interval
+		return nil
+	}
+}
+
+// WithBeaconVersion sets the version of the beacons the node emits, see
+// SetBeaconVersion.
+func WithBeaconVersion(version int) Option {
+	return func(c *config) error {
+		if version < 0 || version > beaconV2 {
+			return fmt.Errorf("%w: unknown beacon version %d", ErrInvalidConfig, version)
+		}
+		c.beaconVersion = byte(version)
+		return nil
+	}
+}
+
+// WithCluster makes the node part of a named cluster, see SetCluster.
+func WithCluster(name string) Option {
+	return func(c *config) error {
+		if len(name) > maxClusterLen {
+			return fmt.Errorf("%w: cluster name is longer than %d bytes", ErrInvalidConfig, maxClusterLen)
+		}
+		c.cluster = name
+		return nil
+	}
+}
+
+// WithBeaconSecret switches the node to shared secret mode, see
+// SetBeaconSecret. An empty secret switches the mode off.
+func WithBeaconSecret(secret string) Option {
+	return func(c *config) error {
+		c.beaconSecret = nil
+		if secret != "" {
+			c.beaconSecret = []byte(secret)
+		}
+		return nil
+	}
+}
+
+// WithBeaconConn makes the beacons go through the given connections rather
+// than the network, see SetBeaconConn.
+func WithBeaconConn(conns ...beacon.PacketConn) Option {
+	return func(c *config) error {
+		for _, conn := range conns {
+			if conn == nil {
+				return fmt.Errorf("%w: nil beacon connection", ErrInvalidConfig)
+			}
+		}
+		c.beaconConns = append(c.beaconConns, conns...)
+		return nil
+	}
+}
+
+// WithHeaders sets node headers; these are provided to other nodes during
+// discovery and come in each ENTER message.
+func WithHeaders(headers map[string]string) Option {
+	return func(c *config) error {
+		for key, val := range headers {
+			if key == "" {
+				return fmt.Errorf("%w: empty header name", ErrInvalidConfig)
+			}
+			c.headers[key] = val
+		}
+		return nil
+	}
+}
+
+// WithVerbose tells the node to log all traffic as well as all major events.
+func WithVerbose() Option {
+	return func(c *config) error {
+		c.verbose = true
+		return nil
+	}
+}
+
+// WithEndpoint binds the node to the given endpoint and switches it to gossip
+// discovery, see SetEndpoint. Gossip should be set up as well using
+// WithGossipBind or WithGossipConnect, unless peers are connected to using
+// RequirePeer only. To beacon the endpoint as well, pass WithPort; the
+// beacon options are rejected otherwise.
+func WithEndpoint(endpoint string) Option {
+	return func(c *config) error {
+		if err := validEndpoint(endpoint); err != nil {
+			return err
+		}
+		c.endpoint = endpoint
+		return nil
+	}
+}
+
+// WithGossipBind binds the gossip engine to a well-known endpoint, see
+// GossipBind.
+func WithGossipBind(endpoint string) Option {
+	return func(c *config) error {
+		if err := validEndpoint(endpoint); err != nil {
+			return err
+		}
+		c.gossipBind = endpoint
+		return nil
+	}
+}
+
+// WithGossipConnect connects the gossip engine to one or more well-known
+// gossip endpoints, see GossipConnect.
+func WithGossipConnect(endpoints ...string) Option {
+	return func(c *config) error {
+		for _, endpoint := range endpoints {
+			if err := validEndpoint(endpoint); err != nil {
+				return err
+			}
+		}
+		c.gossipConnect = append(c.gossipConnect, endpoints...)
+		return nil
+	}
+}
+
+// WithCertificate sets the CURVE certificate of the node, see SetCertificate.
+func WithCertificate(publicKey, secretKey string) Option {
+	return func(c *config) error {
+		if err := validKey(publicKey); err != nil {
+			return err
+		}
+		if err := validKey(secretKey); err != nil {
+			return err
+		}
+		c.publicKey = publicKey
+		c.secretKey = secretKey
+		return nil
+	}
+}
+
+// WithPublicKey sets the CURVE public key of a peer, see SetPublicKey.
+func WithPublicKey(peer, publicKey string) Option {
+	return func(c *config) error {
+		if err := validKey(publicKey); err != nil {
+			return err
+		}
+		c.peerKeys[peer] = publicKey
+		return nil
+	}
+}
+
+// WithAuthenticator sets the hook deciding which CURVE keys may connect to
+// the node, see SetAuthenticator.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(c *config) error {
+		c.authenticator = auth
+		return nil
+	}
+}
+
+// WithStaticPeers adds endpoints to the static peer list, see SetStaticPeers.
+func WithStaticPeers(endpoints ...string) Option {
+	return func(c *config) error {
+		for _, endpoint := range endpoints {
+			if err := validEndpoint(endpoint); err != nil {
+				return err
+			}
+		}
+		c.staticPeers = append(c.staticPeers, endpoints...)
+		return nil
+	}
+}
+
+// WithStaticPeersFile adds the endpoints listed in a file to the static peer
+// list, see LoadStaticPeers.
+func WithStaticPeersFile(path string) Option {
+	return func(c *config) error {
+		endpoints, err := LoadStaticPeers(path)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+		}
+		c.staticPeers = append(c.staticPeers, endpoints...)
+		return nil
+	}
+}
+
+// WithDiscovery adds discovery backends to find peers through, see
+// SetDiscovery.
+func WithDiscovery(backends ...Discovery) Option {
+	return func(c *config) error {
+		for _, d := range backends {
+			if d == nil {
+				return fmt.Errorf("%w: nil discovery", ErrInvalidConfig)
+			}
+		}
+		c.discoveries = append(c.discoveries, backends...)
+		return nil
+	}
+}
+
+// WithEvasive sets the period of silence after which a peer is considered
+// evasive and gets pinged. Defaults to 3 seconds.
+func WithEvasive(evasive time.Duration) Option {
+	return func(c *config) error {
+		if evasive <= 0 {
+			return fmt.Errorf("%w: evasive timeout must be positive", ErrInvalidConfig)
+		}
+		c.evasive = evasive
+		return nil
+	}
+}
+
+// WithExpired sets the period of silence after which a peer is considered
+// gone. Defaults to 5 seconds.
+func WithExpired(expired time.Duration) Option {
+	return func(c *config) error {
+		if expired <= 0 {
+			return fmt.Errorf("%w: expired timeout must be positive", ErrInvalidConfig)
+		}
+		c.expired = expired
+		return nil
+	}
+}
+
+// WithLoopInterval sets the interval of checking health of the peers.
+// Defaults to 1 second.
+func WithLoopInterval(interval time.Duration) Option {
+	return func(c *config) error {
+		if interval <= 0 {
+			return fmt.Errorf("%w: loop interval must be positive", ErrInvalidConfig)
+		}
+		c.loopInterval = interval
+		return nil
+	}
+}
+
+// WithTimeout sets how long API calls wait for the node, see SetTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return fmt.Errorf("%w: timeout must be positive", ErrInvalidConfig)
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// newConfig returns the default configuration.
+func newConfig() *config {
+	c := &config{
+		port:     zreDiscoveryPort,
+		headers:  make(map[string]string),
+		peerKeys: make(map[string]string),
+		timeout:  defaultTimeout,
+	}
+
+	optMx.Lock()
+	c.evasive = peerEvasive
+	c.expired = peerExpired
+	c.loopInterval = loopInterval
+	optMx.Unlock()
+
+	return c
+}
+
+// validate checks the options against each other.
+func (c *config) validate() error {
+	gossip := c.gossipBind != "" || len(c.gossipConnect) > 0
+
+	if c.evasive >= c.expired {
+		return fmt.Errorf("%w: evasive timeout (%s) must be shorter than expired timeout (%s)", ErrInvalidConfig, c.evasive, c.expired)
+	}
+	if c.port == 0 && c.endpoint == "" && !gossip && len(c.staticPeers) == 0 && len(c.discoveries) == 0 {
+		return fmt.Errorf("%w: beaconing is disabled but neither endpoint, gossip, static peers nor discovery are set", ErrInvalidConfig)
+	}
+	if (c.endpoint != "" || gossip) && !c.portSet && (c.beaconVersion != 0 || c.beaconSecret != nil) {
+		return fmt.Errorf("%w: endpoint and gossip disable beaconing, use WithPort to beacon as well", ErrInvalidConfig)
+	}
+	if c.authenticator != nil && c.secretKey == "" {
+		return fmt.Errorf("%w: authenticator is set but certificate is not, use WithCertificate", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// apply applies the configuration to a node which isn't running yet.
+func (c *config) apply(n *node) (err error) {
+	n.beaconPort = c.port
+	n.interval = c.interval
+	n.beaconVersion = c.beaconVersion
+	n.beaconSecret = c.beaconSecret
+	n.verbose = c.verbose
+	n.evasive = c.evasive
+	n.expired = c.expired
+	n.loopInterval = c.loopInterval
+
+	if c.name != "" {
+		n.name = c.name
+	}
+	if len(c.ifaces) != 0 {
+		n.beacon.SetInterface(c.ifaces...)
+	}
+	if c.family != "" {
+		n.setFamily(c.family)
+	}
+	if len(c.beaconConns) != 0 {
+		n.beacon.SetPacketConn(c.beaconConns...)
+	}
+	for key, val := range c.headers {
+		n.headers[key] = val
+	}
+	if c.cluster != "" {
+		err = n.setCluster(c.cluster)
+		if err != nil {
+			return err
+		}
+	}
+	for peer, key := range c.peerKeys {
+		n.peerKeys[peer] = key
+	}
+
+	// The inbox must become a CURVE server before it's bound
+	if c.secretKey != "" {
+		err = n.setCertificate(c.publicKey, c.secretKey)
+		if err != nil {
+			return err
+		}
+	}
+	if c.authenticator != nil {
+		err = n.setAuthenticator(c.authenticator)
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.discoveries) > 0 {
+		err = n.setDiscovery(c.discoveries)
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.staticPeers) > 0 {
+		err = n.setStaticPeers(c.staticPeers)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.endpoint != "" {
+		err = n.setEndpoint(c.endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	if c.gossipBind != "" {
+		err = n.bindGossip(c.gossipBind)
+		if err != nil {
+			return err
+		}
+	}
+	for _, endpoint := range c.gossipConnect {
+		err = n.connectGossip(endpoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The endpoint and gossip disable beaconing, unless a port was given
+	if c.portSet {
+		n.beaconPort = c.port
+	}
+
+	return nil
+}
+
+// validEndpoint checks that endpoint looks like a ZeroMQ endpoint.
+func validEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("%w: endpoint %q has no transport", ErrInvalidConfig, endpoint)
+	}
+
+	return nil
+}
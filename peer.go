@@ -9,6 +9,14 @@ import (
 	"github.com/zeromq/gyre/zre/msg"
 )
 
+const (
+	// Set a high-water mark that allows for reasonable activity, this is
+	// what Zyre derives from the default expired timeout (5000 msecs * 100)
+	peerSndhwm = 500000
+)
+
+// Defaults of the peer timers of new nodes. Each node keeps its own copy which
+// can be changed by WithEvasive, WithExpired, WithLoopInterval or at runtime.
 var (
 	optMx        sync.Mutex
 	peerEvasive  = 3 * time.Second // peerEvasive seconds' silence is evasive
@@ -16,29 +24,72 @@ var (
 	loopInterval = 1 * time.Second
 )
 
+// PeerLifecycle is the state of a peer as seen by the local node. A peer
+// moves from PeerConnecting to PeerReady once it has said HELLO, becomes
+// PeerEvasive and then PeerSilent as it goes quiet and PeerExpired when we
+// give up on it or can't send to it anymore. Any traffic from an evasive or
+// silent peer makes it ready again.
+type PeerLifecycle int
+
+// Peer lifecycle states
+const (
+	PeerConnecting PeerLifecycle = iota + 1 // We've connected, waiting for HELLO
+	PeerReady                               // Peer has said HELLO and is talking to us
+	PeerEvasive                             // Peer has gone quiet, we've pinged it
+	PeerSilent                              // Peer hasn't answered our ping
+	PeerExpired                             // Peer has gone away, we're disconnected
+)
+
+// String converts PeerLifecycle to string.
+func (l PeerLifecycle) String() string {
+	switch l {
+	case PeerConnecting:
+		return "Connecting"
+	case PeerReady:
+		return "Ready"
+	case PeerEvasive:
+		return "Evasive"
+	case PeerSilent:
+		return "Silent"
+	case PeerExpired:
+		return "Expired"
+	}
+
+	return ""
+}
+
+// MarshalText marshals the state as its name, e.g. in a NodeState.
+func (l PeerLifecycle) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
 type peer struct {
 	mailbox      *zmq.Socket // Socket through to peer
 	identity     string
 	endpoint     string            // Endpoint connected to
 	name         string            // Peer's public name
 	evasiveAt    time.Time         // Peer is being evasive
+	silentAt     time.Time         // Peer is silent, it didn't answer our ping
 	expiredAt    time.Time         // Peer has expired by now
+	state        PeerLifecycle     // Where the peer is in its lifecycle
 	connected    bool              // Peer will send messages
-	ready        bool              // Peer has said Hello to us
 	status       byte              // Our status counter
 	sentSequence uint16            // Outgoing message sequence
 	wantSequence uint16            // Incoming message sequence
 	headers      map[string]string // Peer headers
+	serverKey    string            // Peer's CURVE public key, if any
+	publicKey    string            // Our CURVE public key
+	secretKey    string            // Our CURVE secret key
+	backoff      time.Duration     // Longest pause between reconnects, if any
 }
 
-// newPeer creates a new peer
+// newPeer creates a new peer, its timers start ticking once it's refreshed
 func newPeer(identity string) (p *peer) {
 	p = &peer{
 		identity: identity,
 		name:     fmt.Sprintf("%.6s", identity),
 		headers:  make(map[string]string),
 	}
-	p.refresh()
 	return
 }
 
@@ -71,10 +122,21 @@ func (p *peer) connect(from []byte, endpoint string) (err error) {
 	routingID := append([]byte{1}, from...)
 	p.mailbox.SetIdentity(string(routingID))
 
+	// Authenticate ourselves and the peer, if it has a CURVE key
+	if p.serverKey != "" {
+		err = p.mailbox.ClientAuthCurve(p.serverKey, p.publicKey, p.secretKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Back off exponentially while the peer isn't there
+	if p.backoff > 0 {
+		p.mailbox.SetReconnectIvlMax(p.backoff)
+	}
+
 	// Set a high-water mark that allows for reasonable activity
-	optMx.Lock()
-	p.mailbox.SetSndhwm(int(peerExpired * time.Microsecond))
-	optMx.Unlock()
+	p.mailbox.SetSndhwm(peerSndhwm)
 
 	// Send messages immediately or return EAGAIN
 	p.mailbox.SetSndtimeo(0)
@@ -86,12 +148,26 @@ func (p *peer) connect(from []byte, endpoint string) (err error) {
 	}
 	p.endpoint = endpoint
 	p.connected = true
-	p.ready = false
+	p.state = PeerConnecting
 
 	return nil
 }
 
-// disconnects peer mailbox. No more messages will be sent to peer until connected again
+// setCurve makes the peer connect using CURVE security, with serverKey being
+// the peer's public key and publicKey, secretKey our own certificate.
+func (p *peer) setCurve(serverKey, publicKey, secretKey string) {
+	p.serverKey = serverKey
+	p.publicKey = publicKey
+	p.secretKey = secretKey
+}
+
+// setBackoff makes the peer back off exponentially, up to max, while trying to
+// reconnect.
+func (p *peer) setBackoff(max time.Duration) {
+	p.backoff = max
+}
+
+// disconnects peer mailbox and expires the peer. No more messages will be sent to peer until connected again
 func (p *peer) disconnect() {
 	if p.connected {
 		if p.mailbox != nil {
@@ -101,8 +177,13 @@ func (p *peer) disconnect() {
 		}
 		p.endpoint = ""
 		p.connected = false
-		p.ready = false
 	}
+	p.state = PeerExpired
+}
+
+// ready tells whether the peer has said HELLO to us and is still connected
+func (p *peer) ready() bool {
+	return p.state == PeerReady || p.state == PeerEvasive || p.state == PeerSilent
 }
 
 // send sends message to peer
@@ -119,13 +200,13 @@ func (p *peer) send(t msg.Transit) (err error) {
 	return
 }
 
-// refresh refreshes activity at peer
-func (p *peer) refresh() {
-	optMx.Lock()
-	defer optMx.Unlock()
-
-	p.evasiveAt = time.Now().Add(peerEvasive)
-	p.expiredAt = time.Now().Add(peerExpired)
+// refresh refreshes activity at peer, the peer becomes evasive and expires
+// after the given periods of silence. Halfway between the two it's silent.
+func (p *peer) refresh(evasive, expired time.Duration) {
+	now := time.Now()
+	p.evasiveAt = now.Add(evasive)
+	p.silentAt = now.Add(evasive + (expired-evasive)/2)
+	p.expiredAt = now.Add(expired)
 }
 
 // checkMessage checks peer message sequence
@@ -159,7 +240,9 @@ func (p *peer) Identity() string {
 	return p.identity
 }
 
-// SetExpired sets expired.
+// SetExpired sets the default expired timeout of the nodes created afterwards.
+//
+// Deprecated: use WithExpired or Gyre.SetExpired which only affect one node.
 func SetExpired(expired time.Duration) {
 	optMx.Lock()
 	defer optMx.Unlock()
@@ -167,7 +250,9 @@ func SetExpired(expired time.Duration) {
 	peerExpired = expired
 }
 
-// SetEvasive sets evasive.
+// SetEvasive sets the default evasive timeout of the nodes created afterwards.
+//
+// Deprecated: use WithEvasive or Gyre.SetEvasive which only affect one node.
 func SetEvasive(evasive time.Duration) {
 	optMx.Lock()
 	defer optMx.Unlock()
@@ -175,7 +260,11 @@ func SetEvasive(evasive time.Duration) {
 	peerEvasive = evasive
 }
 
-// SetLoopInterval sets interval of checking health of other peers
+// SetLoopInterval sets the default interval of checking health of other peers
+// of the nodes created afterwards.
+//
+// Deprecated: use WithLoopInterval or Gyre.SetLoopInterval which only affect
+// one node.
 func SetLoopInterval(interval time.Duration) {
 	optMx.Lock()
 	defer optMx.Unlock()
@@ -32,8 +32,8 @@ func TestPeer(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !peer.connected {
-		t.Fatal("Peer should be connected")
+	if !peer.connected || peer.state != PeerConnecting {
+		t.Fatalf("Peer should be connected, got %s", peer.state)
 	}
 
 	m := msg.NewHello()
@@ -55,5 +55,10 @@ func TestPeer(t *testing.T) {
 		t.Error("Hello message was corrupted")
 	}
 
+	// Once disconnected, e.g. when sending fails, the peer has expired
+	peer.state = PeerReady
 	peer.destroy()
+	if peer.connected || peer.ready() || peer.state != PeerExpired {
+		t.Errorf("Peer should have expired, got %s", peer.state)
+	}
 }
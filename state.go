@@ -0,0 +1,141 @@
+package gyre
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// NodeState is a snapshot of a node's internal state as returned by Dump.
+// It's safe to marshal it to JSON, e.g. to attach it to a bug report.
+type NodeState struct {
+	UUID         string            `json:"uuid"`
+	Name         string            `json:"name"`
+	Endpoint     string            `json:"endpoint"`
+	BeaconPort   int               `json:"beacon_port"`
+	Status       byte              `json:"status"`
+	Resyncs      uint64            `json:"resyncs"`
+	Lost         uint64            `json:"lost"`
+	Evasive      time.Duration     `json:"evasive"`
+	Expired      time.Duration     `json:"expired"`
+	LoopInterval time.Duration     `json:"loop_interval"`
+	Headers      map[string]string `json:"headers"`
+	OwnGroups    []string          `json:"own_groups"`
+	Leaders      map[string]string `json:"leaders"`
+	Peers        []PeerState       `json:"peers"`
+}
+
+// PeerState is a snapshot of a single peer as seen by the local node.
+type PeerState struct {
+	UUID         string            `json:"uuid"`
+	Name         string            `json:"name"`
+	Endpoint     string            `json:"endpoint"`
+	Connected    bool              `json:"connected"`
+	Ready        bool              `json:"ready"`
+	State        PeerLifecycle     `json:"state"`
+	SentSequence uint16            `json:"sent_sequence"`
+	WantSequence uint16            `json:"want_sequence"`
+	Status       byte              `json:"status"`
+	EvasiveAt    time.Time         `json:"evasive_at"`
+	ExpiredAt    time.Time         `json:"expired_at"`
+	Headers      map[string]string `json:"headers"`
+	Groups       []string          `json:"groups"`
+}
+
+// GossipHubState is the state of a gossip endpoint the node has connected to,
+// as returned by GossipHubs.
+type GossipHubState struct {
+	Endpoint  string    `json:"endpoint"`
+	Connected bool      `json:"connected"`
+	LastHeard time.Time `json:"last_heard"`
+	Failures  int       `json:"failures"`
+	RetryAt   time.Time `json:"retry_at"`
+}
+
+// BeaconRejections counts the beacons a node has dropped in shared secret
+// mode, as returned by BeaconRejections.
+type BeaconRejections struct {
+	Unauthenticated uint64 `json:"unauthenticated"` // Not signed with the secret
+	Replayed        uint64 `json:"replayed"`        // Not fresh, or older than the last one
+}
+
+// String returns the state as indented JSON.
+func (s *NodeState) String() string {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(out)
+}
+
+// state takes a snapshot of the node. Everything is copied so the result
+// can be handed over to the API safely.
+func (n *node) state() *NodeState {
+	s := &NodeState{
+		UUID:         n.identity(),
+		Name:         n.name,
+		Endpoint:     n.endpoint,
+		BeaconPort:   n.beaconPort,
+		Status:       n.status,
+		Resyncs:      n.resyncs,
+		Lost:         n.lost,
+		Evasive:      n.evasive,
+		Expired:      n.expired,
+		LoopInterval: n.loopInterval,
+		Headers:      make(map[string]string, len(n.headers)),
+		OwnGroups:    make([]string, 0, len(n.ownGroups)),
+		Leaders:      make(map[string]string, len(n.leaders)),
+		Peers:        make([]PeerState, 0, len(n.peers)),
+	}
+
+	for key, val := range n.headers {
+		s.Headers[key] = val
+	}
+
+	for name := range n.ownGroups {
+		s.OwnGroups = append(s.OwnGroups, name)
+	}
+	sort.Strings(s.OwnGroups)
+
+	for group, leader := range n.leaders {
+		s.Leaders[group] = leader
+	}
+
+	for _, peer := range n.peers {
+		ps := PeerState{
+			UUID:         peer.identity,
+			Name:         peer.name,
+			Endpoint:     peer.endpoint,
+			Connected:    peer.connected,
+			Ready:        peer.ready(),
+			State:        peer.state,
+			SentSequence: peer.sentSequence,
+			WantSequence: peer.wantSequence,
+			Status:       peer.status,
+			EvasiveAt:    peer.evasiveAt,
+			ExpiredAt:    peer.expiredAt,
+			Headers:      make(map[string]string, len(peer.headers)),
+			Groups:       []string{},
+		}
+
+		for key, val := range peer.headers {
+			ps.Headers[key] = val
+		}
+
+		for name, group := range n.peerGroups {
+			if _, ok := group.peers[peer.identity]; ok {
+				ps.Groups = append(ps.Groups, name)
+			}
+		}
+		sort.Strings(ps.Groups)
+
+		s.Peers = append(s.Peers, ps)
+	}
+
+	sort.Slice(s.Peers, func(i, j int) bool {
+		return s.Peers[i].UUID < s.Peers[j].UUID
+	})
+
+	return s
+}
@@ -0,0 +1,216 @@
+package gyre
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Longest pause between attempts to reach a static peer
+	staticBackoff = 30 * time.Second
+)
+
+// staticPeer is an endpoint of the static peer list. Until the node there has
+// said HELLO we don't know its UUID, so we greet it through a probe which
+// becomes the peer once the HELLO comes back. The probe keeps reconnecting,
+// backing off exponentially, until the node is there.
+type staticPeer struct {
+	endpoint string
+	port     string   // Port of a TCP endpoint
+	ips      []net.IP // Addresses of a TCP endpoint's host, resolved once
+	probe    *peer    // Greets the endpoint until it answers
+	identity string   // UUID of the node at the endpoint, once known
+	self     bool     // The endpoint is our own
+}
+
+// newStaticPeer creates a static peer. The host name of a TCP endpoint is
+// resolved here, once when the list is set, rather than whenever endpoints
+// are matched, so a slow resolver doesn't stall the node on every tick.
+func newStaticPeer(endpoint string) *staticPeer {
+	sp := &staticPeer{endpoint: endpoint}
+	if u, err := url.Parse(endpoint); err == nil && u.Scheme == "tcp" {
+		sp.port = u.Port()
+		sp.ips = lookupHost(u.Hostname())
+	}
+
+	return sp
+}
+
+// LoadStaticPeers reads a static peer list from a file, one endpoint per
+// line. Empty lines and lines starting with # are skipped.
+func LoadStaticPeers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var endpoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validEndpoint(line); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		endpoints = append(endpoints, line)
+	}
+
+	return endpoints, scanner.Err()
+}
+
+// setStaticPeers replaces the static peer list
+func (n *node) setStaticPeers(endpoints []string) error {
+	for _, endpoint := range endpoints {
+		if err := validEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+
+	static := make(map[string]*staticPeer, len(endpoints))
+	for _, endpoint := range endpoints {
+		if sp, ok := n.static[endpoint]; ok {
+			static[endpoint] = sp
+			delete(n.static, endpoint)
+		} else {
+			static[endpoint] = newStaticPeer(endpoint)
+		}
+	}
+
+	// Stop greeting endpoints which aren't listed anymore, peers we've
+	// already met stay around until they expire
+	for _, sp := range n.static {
+		if sp.probe != nil {
+			sp.probe.destroy()
+		}
+	}
+	n.static = static
+
+	return nil
+}
+
+// checkStatic greets the static peers we aren't connected to. It's called on
+// each tick, once the node has been started.
+func (n *node) checkStatic() {
+	for _, sp := range n.static {
+		if sp.self || sp.probe != nil {
+			continue
+		}
+		if sp.identity != "" {
+			if _, ok := n.peers[sp.identity]; ok {
+				continue
+			}
+			// The peer has gone, greet whoever is there now
+			sp.identity = ""
+		}
+		if sp.at(n.endpoint) {
+			sp.self = true
+			continue
+		}
+
+		// We may have met the peer already, e.g. it has greeted us first
+		if peer := n.peerAt(sp); peer != nil {
+			sp.identity = peer.identity
+			continue
+		}
+
+		// With CURVE we'd need the key of a peer we don't know yet
+		if n.secretKey != "" {
+			if n.verbose {
+				log.Printf("[%s] Can't greet static peer %s, CURVE needs its key", n.name, sp.endpoint)
+			}
+			continue
+		}
+
+		probe := newPeer("")
+		probe.setBackoff(staticBackoff)
+		err := probe.connect(n.uuid, sp.endpoint)
+		if err != nil {
+			log.Printf("[%s] Can't greet static peer %s: %s", n.name, sp.endpoint, err)
+			continue
+		}
+		probe.send(n.hello())
+		sp.probe = probe
+	}
+}
+
+// adoptStatic turns the probe greeting the given endpoint into the peer which
+// has just said HELLO from there, if there is such a probe.
+func (n *node) adoptStatic(identity, endpoint string) *peer {
+	for _, sp := range n.static {
+		if sp.probe == nil || !sp.at(endpoint) {
+			continue
+		}
+
+		probe := sp.probe
+		sp.probe = nil
+		if identity == n.identity() {
+			// We've greeted ourselves
+			sp.self = true
+			probe.destroy()
+			return nil
+		}
+
+		sp.identity = identity
+		probe.identity = identity
+		probe.name = fmt.Sprintf("%.6s", identity)
+		probe.refresh(n.evasive, n.expired)
+		n.peers[identity] = probe
+
+		return probe
+	}
+
+	return nil
+}
+
+// peerAt returns the peer connected to the static peer's endpoint, if any
+func (n *node) peerAt(sp *staticPeer) *peer {
+	for _, peer := range n.peers {
+		if sp.at(peer.endpoint) {
+			return peer
+		}
+	}
+
+	return nil
+}
+
+// at tells whether an endpoint leads to the static peer, i.e. it's the same
+// endpoint or a TCP endpoint on the same port of one of its addresses. The
+// endpoint's host must be an IP address, as peers advertise; it isn't
+// resolved.
+func (sp *staticPeer) at(endpoint string) bool {
+	if endpoint == sp.endpoint {
+		return true
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "tcp" || sp.port == "" || u.Port() != sp.port {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	for _, addr := range sp.ips {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupHost returns the addresses of a host name or IP address
+func lookupHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, _ := net.LookupIP(host)
+
+	return ips
+}
@@ -0,0 +1,70 @@
+package msg
+
+import (
+	"testing"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// Yay! Test function.
+func TestPong(t *testing.T) {
+
+	// Create pair of sockets we can send through
+
+	// Output socket
+	output, err := zmq.NewSocket(zmq.DEALER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	routingID := "Shout"
+	output.SetIdentity(routingID)
+	err = output.Bind("inproc://selftest-pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Unbind("inproc://selftest-pong")
+
+	// Input socket
+	input, err := zmq.NewSocket(zmq.ROUTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	err = input.Connect("inproc://selftest-pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Disconnect("inproc://selftest-pong")
+
+	// Create a Pong message and send it through the wire
+	pong := NewPong()
+
+	err = pong.Send(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transit, err := Recv(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := transit.(*Pong)
+
+	err = tr.Send(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transit, err = Recv(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if routingID != string(tr.RoutingID()) {
+		t.Fatalf("expected %s, got %s", routingID, string(tr.RoutingID()))
+	}
+}
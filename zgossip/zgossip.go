@@ -0,0 +1,664 @@
+// Package zgossip implements the ZeroMQ gossip discovery protocol, as defined
+// by CZMQ's zgossip. Each engine is a server which other engines connect to
+// and a client of the servers it connects to. Tuples published anywhere in
+// the network are forwarded to every engine; they can be withdrawn and may
+// carry a time to live, after which they expire unless published again.
+package zgossip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/zeromq/gyre/zgossip/msg"
+)
+
+const (
+	// How often the engine checks its remotes, clients and tuples
+	tick = 100 * time.Millisecond
+
+	// How often we ping the servers we're connected to
+	pingInterval = 1 * time.Second
+
+	// Silence after which a client has gone, it pings us every pingInterval
+	clientExpired = 5 * pingInterval
+
+	// Silence after which we reconnect to a server, and the longest pause
+	// between attempts while it's not there
+	remoteExpired = 3 * pingInterval
+	maxBackoff    = 30 * time.Second
+)
+
+// ErrClosed is returned by the calls made after the engine is closed.
+var ErrClosed = errors.New("gossip engine is closed")
+
+// Tuple is a key/value pair gossiped by the engines. A tuple without a value
+// has been withdrawn or has expired.
+type Tuple struct {
+	Key   string
+	Value string
+}
+
+// Gossip is a gossip engine. Its methods are safe for concurrent use, the
+// sockets are owned by the engine's goroutine.
+type Gossip struct {
+	identity string
+	cmds     chan interface{}
+	tuples   chan Tuple // Tuples published by the other engines
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// Owned by the engine's goroutine
+	reactor *zmq.Reactor
+	verbose bool
+	server  *zmq.Socket            // Clients connect to us here, once bound
+	port    uint16                 // Port the server is bound to, if TCP
+	remotes map[string]*remote     // Servers we're connected to by endpoint
+	clients map[string]time.Time   // Clients by routing id, and when heard
+	store   map[string]*storeTuple // Tuples we know of by key
+}
+
+type cmd struct {
+	cmd     string
+	payload interface{}
+	replies chan *reply
+}
+
+type reply struct {
+	payload interface{}
+	err     error
+}
+
+const (
+	cmdBind     = "BIND"
+	cmdConnect  = "CONNECT"
+	cmdPublish  = "PUBLISH"
+	cmdWithdraw = "WITHDRAW"
+	cmdPort     = "PORT"
+	cmdRemotes  = "REMOTES"
+	cmdVerbose  = "VERBOSE"
+	cmdTerm     = "$TERM"
+)
+
+// remote is a server we're connected to
+type remote struct {
+	endpoint  string
+	socket    *zmq.Socket // Nil while we're waiting to reconnect
+	pingAt    time.Time   // When we ping it next
+	openedAt  time.Time   // When we last (re)connected
+	heardAt   time.Time   // When we last heard from it, if ever
+	connected bool        // It has talked to us since we've connected
+	failures  int         // Reconnects since we last heard from it
+	retryAt   time.Time   // When we reconnect, once the socket is closed
+}
+
+// RemoteState is the state of a server the engine has connected to.
+type RemoteState struct {
+	Endpoint  string
+	Connected bool      // The server is talking to us
+	LastHeard time.Time // When we last heard from it, if ever
+	Failures  int       // Reconnects since we last heard from it
+	RetryAt   time.Time // When we reconnect next, if not connected
+}
+
+// storeTuple is a tuple with its expiry
+type storeTuple struct {
+	value     string
+	expiresAt time.Time // Zero if the tuple doesn't expire
+	local     bool      // We've published it ourselves
+}
+
+// publication is the payload of the PUBLISH command
+type publication struct {
+	key   string
+	value string
+	ttl   time.Duration
+}
+
+// New creates a gossip engine, identity is used in logs only.
+func New(identity string) (*Gossip, error) {
+	g := &Gossip{
+		identity: identity,
+		cmds:     make(chan interface{}),
+		tuples:   make(chan Tuple, 1000),
+		done:     make(chan struct{}),
+		reactor:  zmq.NewReactor(),
+		remotes:  make(map[string]*remote),
+		clients:  make(map[string]time.Time),
+		store:    make(map[string]*storeTuple),
+	}
+
+	g.wg.Add(1)
+	go g.actor()
+
+	return g, nil
+}
+
+// Bind binds the engine to an endpoint other engines can connect to. A TCP
+// endpoint may use * as port, see Port.
+func (g *Gossip) Bind(endpoint string) error {
+	_, err := g.request(cmdBind, endpoint)
+	return err
+}
+
+// Connect connects the engine to the endpoint another engine is bound to.
+func (g *Gossip) Connect(endpoint string) error {
+	_, err := g.request(cmdConnect, endpoint)
+	return err
+}
+
+// Publish publishes a tuple to the network. A tuple with a time to live
+// expires unless it's published again in time, zero means it never does.
+func (g *Gossip) Publish(key, value string, ttl time.Duration) error {
+	if value == "" {
+		return errors.New("gossip: can't publish an empty value, use Withdraw")
+	}
+	if ttl < 0 || ttl/time.Millisecond > 0xffffffff {
+		return fmt.Errorf("gossip: invalid time to live %s", ttl)
+	}
+	_, err := g.request(cmdPublish, &publication{key: key, value: value, ttl: ttl})
+	return err
+}
+
+// Withdraw removes a tuple from the network.
+func (g *Gossip) Withdraw(key string) error {
+	_, err := g.request(cmdWithdraw, key)
+	return err
+}
+
+// Remotes returns the state of the servers the engine has connected to,
+// sorted by endpoint.
+func (g *Gossip) Remotes() ([]RemoteState, error) {
+	out, err := g.request(cmdRemotes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.([]RemoteState), nil
+}
+
+// Port returns the port the engine is bound to, zero if it isn't bound to a
+// TCP endpoint.
+func (g *Gossip) Port() (uint16, error) {
+	out, err := g.request(cmdPort, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return out.(uint16), nil
+}
+
+// SetVerbose makes the engine log all traffic.
+func (g *Gossip) SetVerbose(verbose bool) error {
+	_, err := g.request(cmdVerbose, verbose)
+	return err
+}
+
+// Tuples returns the channel of tuples published, withdrawn or expired by
+// the other engines. It's closed once the engine is closed.
+func (g *Gossip) Tuples() <-chan Tuple {
+	return g.tuples
+}
+
+// Close shuts the engine down.
+func (g *Gossip) Close() error {
+	_, err := g.request(cmdTerm, nil)
+	if err == ErrClosed {
+		return nil
+	}
+	g.wg.Wait()
+
+	return err
+}
+
+// request sends a command to the engine and waits for the reply
+func (g *Gossip) request(name string, payload interface{}) (interface{}, error) {
+	c := &cmd{cmd: name, payload: payload, replies: make(chan *reply, 1)}
+
+	select {
+	case g.cmds <- c:
+	case <-g.done:
+		return nil, ErrClosed
+	}
+
+	select {
+	case r := <-c.replies:
+		return r.payload, r.err
+	case <-g.done:
+		return nil, ErrClosed
+	}
+}
+
+func (g *Gossip) actor() {
+	defer g.wg.Done()
+	defer close(g.tuples)
+
+	g.reactor.AddChannel(g.cmds, 1, func(c interface{}) error {
+		return g.recvFromAPI(c.(*cmd))
+	})
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	g.reactor.AddChannelTime(ticker.C, 1, func(interface{}) error {
+		g.check()
+		return nil
+	})
+
+	g.reactor.Run(10 * time.Millisecond)
+}
+
+// recvFromAPI handles a command, an error stops the engine
+func (g *Gossip) recvFromAPI(c *cmd) error {
+	r := &reply{}
+
+	switch c.cmd {
+	case cmdBind:
+		r.err = g.bind(c.payload.(string))
+
+	case cmdConnect:
+		r.err = g.connect(c.payload.(string))
+
+	case cmdPublish:
+		p := c.payload.(*publication)
+		g.accept(p.key, p.value, p.ttl, nil, "")
+
+	case cmdWithdraw:
+		g.accept(c.payload.(string), "", 0, nil, "")
+
+	case cmdPort:
+		r.payload = g.port
+
+	case cmdRemotes:
+		r.payload = g.remoteStates()
+
+	case cmdVerbose:
+		g.verbose = c.payload.(bool)
+
+	case cmdTerm:
+		g.terminate()
+		c.replies <- r
+		close(g.done)
+		return errors.New("terminate")
+	}
+
+	c.replies <- r
+	return nil
+}
+
+// bind binds the server, creating it on first use
+func (g *Gossip) bind(endpoint string) (err error) {
+	if g.server == nil {
+		g.server, err = zmq.NewSocket(zmq.ROUTER)
+		if err != nil {
+			return err
+		}
+		g.server.SetSndtimeo(0)
+		g.reactor.AddSocket(g.server, zmq.POLLIN, func(zmq.State) error {
+			g.recvFromClient()
+			return nil
+		})
+	}
+
+	err = g.server.Bind(endpoint)
+	if err != nil {
+		return err
+	}
+
+	// Find the port we've been given
+	bound, err := g.server.GetLastEndpoint()
+	if err != nil {
+		return err
+	}
+	if u, err := url.Parse(bound); err == nil && u.Scheme == "tcp" {
+		if port, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+			g.port = uint16(port)
+		}
+	}
+
+	return nil
+}
+
+// connect connects to a server, says hello and tells it what we know
+func (g *Gossip) connect(endpoint string) error {
+	if _, ok := g.remotes[endpoint]; ok {
+		return nil
+	}
+
+	r := &remote{endpoint: endpoint}
+	err := g.open(r)
+	if err != nil {
+		return err
+	}
+	g.remotes[endpoint] = r
+
+	return nil
+}
+
+// open (re)connects to a server and greets it
+func (g *Gossip) open(r *remote) error {
+	socket, err := zmq.NewSocket(zmq.DEALER)
+	if err != nil {
+		return err
+	}
+	// Never block the engine on a server which isn't there
+	socket.SetSndtimeo(0)
+	err = socket.Connect(r.endpoint)
+	if err != nil {
+		socket.Close()
+		return err
+	}
+
+	now := time.Now()
+	r.socket = socket
+	r.openedAt = now
+	r.pingAt = now.Add(pingInterval)
+	g.reactor.AddSocket(socket, zmq.POLLIN, func(zmq.State) error {
+		g.recvFromRemote(r)
+		return nil
+	})
+	g.greet(r)
+
+	return nil
+}
+
+// close closes the socket of a server which has gone quiet, we reconnect
+// after a pause which doubles with each failure
+func (g *Gossip) close(r *remote) {
+	// Drop whatever hasn't gone out, we greet it again once it's back
+	g.reactor.RemoveSocket(r.socket)
+	r.socket.SetLinger(0)
+	r.socket.Close()
+	r.socket = nil
+	r.connected = false
+
+	backoff := r.backoff()
+	if g.verbose {
+		log.Printf("[gossip %.6s] %s is silent, reconnecting in %s", g.identity, r.endpoint, backoff)
+	}
+}
+
+// backoff schedules the next attempt to reconnect
+func (r *remote) backoff() time.Duration {
+	backoff := pingInterval << uint(r.failures)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	r.failures++
+	r.retryAt = time.Now().Add(backoff)
+
+	return backoff
+}
+
+// greet says hello to a server, sends it all our tuples and pings it so we
+// know it's there
+func (g *Gossip) greet(r *remote) {
+	g.send(r.socket, msg.NewHello())
+	for key, t := range g.store {
+		g.send(r.socket, t.publish(key))
+	}
+	g.send(r.socket, msg.NewPing())
+}
+
+// remoteStates returns the state of the servers we've connected to
+func (g *Gossip) remoteStates() []RemoteState {
+	states := make([]RemoteState, 0, len(g.remotes))
+	for _, r := range g.remotes {
+		state := RemoteState{
+			Endpoint:  r.endpoint,
+			Connected: r.connected,
+			LastHeard: r.heardAt,
+			Failures:  r.failures,
+		}
+		if r.socket == nil {
+			state.RetryAt = r.retryAt
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Endpoint < states[j].Endpoint })
+
+	return states
+}
+
+// recvFromClient handles a message from a client of our server
+func (g *Gossip) recvFromClient() {
+	m, err := msg.Recv(g.server)
+	if err != nil {
+		if g.verbose {
+			log.Printf("[gossip %.6s] %s", g.identity, err)
+		}
+		return
+	}
+	client := string(m.RoutingID())
+	if g.verbose {
+		log.Printf("[gossip %.6s] Received from client: %s", g.identity, m)
+	}
+
+	if _, ok := m.(*msg.Hello); ok {
+		g.clients[client] = time.Now()
+		for key, t := range g.store {
+			p := t.publish(key)
+			p.SetRoutingID(m.RoutingID())
+			g.send(g.server, p)
+		}
+		return
+	}
+
+	// The client must say hello first, e.g. again after we've forgotten it
+	if _, ok := g.clients[client]; !ok {
+		invalid := msg.NewInvalid()
+		invalid.SetRoutingID(m.RoutingID())
+		g.send(g.server, invalid)
+		return
+	}
+	g.clients[client] = time.Now()
+
+	switch m := m.(type) {
+	case *msg.Publish:
+		g.accept(m.Key, m.Value, time.Duration(m.TTL)*time.Millisecond, nil, client)
+
+	case *msg.Ping:
+		pong := msg.NewPong()
+		pong.SetRoutingID(m.RoutingID())
+		g.send(g.server, pong)
+	}
+}
+
+// recvFromRemote handles a message from a server we're connected to
+func (g *Gossip) recvFromRemote(r *remote) {
+	m, err := msg.Recv(r.socket)
+	if err != nil {
+		if g.verbose {
+			log.Printf("[gossip %.6s] %s", g.identity, err)
+		}
+		return
+	}
+	if g.verbose {
+		log.Printf("[gossip %.6s] Received from %s: %s", g.identity, r.endpoint, m)
+	}
+	r.heardAt = time.Now()
+	r.connected = true
+	r.failures = 0
+
+	switch m := m.(type) {
+	case *msg.Publish:
+		g.accept(m.Key, m.Value, time.Duration(m.TTL)*time.Millisecond, r, "")
+
+	case *msg.Invalid:
+		// The server has forgotten us
+		g.greet(r)
+	}
+}
+
+// accept stores a tuple published by us, a remote or a client, and forwards
+// it to everyone else if it's news. An empty value withdraws the tuple.
+func (g *Gossip) accept(key, value string, ttl time.Duration, from *remote, client string) {
+	local := from == nil && client == ""
+	t, ok := g.store[key]
+
+	if value == "" {
+		if !ok {
+			return
+		}
+		delete(g.store, key)
+		if !local {
+			g.deliver(Tuple{Key: key})
+		}
+		g.forward(key, "", 0, from, client)
+		return
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if ok && t.value == value {
+		// The tuple has been published again to keep it alive. Pass it on
+		// only if that makes it live notably longer, so it doesn't go
+		// round in circles.
+		switch {
+		case expiresAt.IsZero() && !t.expiresAt.IsZero():
+			// It lives forever now
+		case !expiresAt.IsZero() && !t.expiresAt.IsZero() && expiresAt.Sub(t.expiresAt) > ttl/10:
+			// It lives notably longer
+		default:
+			return
+		}
+		t.expiresAt = expiresAt
+		g.forward(key, value, ttl, from, client)
+		return
+	}
+
+	g.store[key] = &storeTuple{value: value, expiresAt: expiresAt, local: local}
+	if !local {
+		g.deliver(Tuple{Key: key, Value: value})
+	}
+	g.forward(key, value, ttl, from, client)
+}
+
+// forward sends a tuple to the remotes and clients but the one it came from
+func (g *Gossip) forward(key, value string, ttl time.Duration, from *remote, client string) {
+	m := msg.NewPublish()
+	m.Key = key
+	m.Value = value
+	m.TTL = uint32(ttl / time.Millisecond)
+
+	for _, r := range g.remotes {
+		if r != from && r.socket != nil {
+			g.send(r.socket, msg.Clone(m))
+		}
+	}
+	for id := range g.clients {
+		if id != client {
+			p := msg.Clone(m)
+			p.SetRoutingID([]byte(id))
+			g.send(g.server, p)
+		}
+	}
+}
+
+// deliver passes a tuple on to the application
+func (g *Gossip) deliver(t Tuple) {
+	select {
+	case g.tuples <- t:
+	default:
+		if g.verbose {
+			log.Printf("[gossip %.6s] Dropping tuple %s", g.identity, t.Key)
+		}
+	}
+}
+
+// check pings the remotes, forgets silent clients and expires tuples
+func (g *Gossip) check() {
+	now := time.Now()
+
+	for _, r := range g.remotes {
+		silence := now.Sub(r.openedAt)
+		if r.heardAt.After(r.openedAt) {
+			silence = now.Sub(r.heardAt)
+		}
+
+		switch {
+		case r.socket == nil:
+			if now.Before(r.retryAt) {
+				break
+			}
+			if err := g.open(r); err != nil {
+				backoff := r.backoff()
+				if g.verbose {
+					log.Printf("[gossip %.6s] Can't reconnect to %s, retrying in %s: %s", g.identity, r.endpoint, backoff, err)
+				}
+			}
+
+		case silence > remoteExpired:
+			g.close(r)
+
+		case !now.Before(r.pingAt):
+			g.send(r.socket, msg.NewPing())
+			r.pingAt = now.Add(pingInterval)
+		}
+	}
+
+	for id, heard := range g.clients {
+		if now.Sub(heard) > clientExpired {
+			delete(g.clients, id)
+		}
+	}
+
+	// Everyone expires the tuples on their own, there's nothing to forward
+	for key, t := range g.store {
+		if !t.expiresAt.IsZero() && !now.Before(t.expiresAt) {
+			delete(g.store, key)
+			if !t.local {
+				g.deliver(Tuple{Key: key})
+			}
+		}
+	}
+}
+
+// send sends a message, logging failures
+func (g *Gossip) send(socket *zmq.Socket, m msg.Transit) {
+	err := m.Send(socket)
+	if err != nil && g.verbose {
+		log.Printf("[gossip %.6s] Can't send %s", g.identity, err)
+	}
+}
+
+// terminate closes all the sockets
+func (g *Gossip) terminate() {
+	for endpoint, r := range g.remotes {
+		if r.socket != nil {
+			g.reactor.RemoveSocket(r.socket)
+			r.socket.Close()
+		}
+		delete(g.remotes, endpoint)
+	}
+	if g.server != nil {
+		g.reactor.RemoveSocket(g.server)
+		g.server.Close()
+		g.server = nil
+	}
+}
+
+// publish builds the PUBLISH message of a tuple, with its remaining time to
+// live
+func (t *storeTuple) publish(key string) *msg.Publish {
+	m := msg.NewPublish()
+	m.Key = key
+	m.Value = t.value
+	if !t.expiresAt.IsZero() {
+		ttl := time.Until(t.expiresAt) / time.Millisecond
+		if ttl < 1 {
+			ttl = 1
+		}
+		m.TTL = uint32(ttl)
+	}
+
+	return m
+}
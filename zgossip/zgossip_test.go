@@ -0,0 +1,225 @@
+package zgossip
+
+import (
+	"testing"
+	"time"
+)
+
+// expect waits for the tuple on the engine's channel
+func expect(t *testing.T, g *Gossip, want Tuple) {
+	t.Helper()
+
+	select {
+	case got := <-g.Tuples():
+		if got != want {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected %v, got nothing", want)
+	}
+}
+
+func TestGossip(t *testing.T) {
+	// A hub and two engines connected to it
+	engines := make([]*Gossip, 3)
+	for i := range engines {
+		var err error
+		engines[i], err = New("engine")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer engines[i].Close()
+	}
+	hub, b, c := engines[0], engines[1], engines[2]
+
+	err := hub.Bind("inproc://zgossip-hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, err := hub.Port(); err != nil || port != 0 {
+		t.Errorf("expected no port, got %d and %v", port, err)
+	}
+
+	// The hub learns what b knows when it connects
+	err = b.Publish("b", "tcp://127.0.0.1:5555", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Connect("inproc://zgossip-hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, hub, Tuple{Key: "b", Value: "tcp://127.0.0.1:5555"})
+
+	// And c learns it from the hub
+	err = c.Connect("inproc://zgossip-hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, Tuple{Key: "b", Value: "tcp://127.0.0.1:5555"})
+
+	// Tuples published later go round as well
+	err = c.Publish("c", "tcp://127.0.0.1:6666", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, hub, Tuple{Key: "c", Value: "tcp://127.0.0.1:6666"})
+	expect(t, b, Tuple{Key: "c", Value: "tcp://127.0.0.1:6666"})
+
+	// So do withdrawals
+	err = b.Withdraw("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, hub, Tuple{Key: "b"})
+	expect(t, c, Tuple{Key: "b"})
+
+	// A tuple which isn't published again expires everywhere
+	err = b.Publish("ttl", "tcp://127.0.0.1:7777", 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, Tuple{Key: "ttl", Value: "tcp://127.0.0.1:7777"})
+	expect(t, c, Tuple{Key: "ttl"})
+
+	if err := b.Publish("empty", "", 0); err == nil {
+		t.Error("expected an error publishing an empty value")
+	}
+
+	// Nothing else has come, e.g. tuples going round in circles
+	select {
+	case tuple := <-c.Tuples():
+		t.Errorf("expected nothing, got %v", tuple)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestGossipRefresh(t *testing.T) {
+	hub, err := New("hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	publisher, err := New("publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	err = hub.Bind("inproc://zgossip-refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Connect("inproc://zgossip-refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tuple lives as long as it's published again in time
+	for i := 0; i < 10; i++ {
+		err = publisher.Publish("key", "value", 300*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	expect(t, hub, Tuple{Key: "key", Value: "value"})
+	select {
+	case tuple := <-hub.Tuples():
+		t.Fatalf("expected nothing, got %v", tuple)
+	default:
+	}
+
+	expect(t, hub, Tuple{Key: "key"})
+}
+
+func TestGossipFailover(t *testing.T) {
+	// Two hubs, both engines are connected to both
+	engines := make([]*Gossip, 4)
+	for i := range engines {
+		var err error
+		engines[i], err = New("engine")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hubs, b, c := engines[:2], engines[2], engines[3]
+	defer b.Close()
+	defer c.Close()
+	defer hubs[1].Close()
+
+	endpoints := []string{"inproc://zgossip-failover-0", "inproc://zgossip-failover-1"}
+	for i, hub := range hubs {
+		err := hub.Bind(endpoints[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, g := range []*Gossip{b, c} {
+		for _, endpoint := range endpoints {
+			err := g.Connect(endpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Both hubs answer right away
+	time.Sleep(200 * time.Millisecond)
+	remotes, err := b.Remotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remotes) != 2 || remotes[0].Endpoint != endpoints[0] || remotes[1].Endpoint != endpoints[1] {
+		t.Fatalf("expected both hubs, got %v", remotes)
+	}
+	for _, r := range remotes {
+		if !r.Connected || r.LastHeard.IsZero() {
+			t.Errorf("expected %s to be connected, got %+v", r.Endpoint, r)
+		}
+	}
+
+	// Gossip keeps going round through the other hub
+	hubs[0].Close()
+	err = b.Publish("b", "tcp://127.0.0.1:5555", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, Tuple{Key: "b", Value: "tcp://127.0.0.1:5555"})
+
+	// Once the hub has been silent for a while, we try to reconnect
+	time.Sleep(remoteExpired + 500*time.Millisecond)
+	remotes, err = b.Remotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := remotes[0]; r.Connected || r.Failures != 1 || r.RetryAt.IsZero() {
+		t.Errorf("expected %s to be reconnecting, got %+v", r.Endpoint, r)
+	}
+	if r := remotes[1]; !r.Connected || r.Failures != 0 {
+		t.Errorf("expected %s to be connected, got %+v", r.Endpoint, r)
+	}
+}
+
+func TestGossipPort(t *testing.T) {
+	g, err := New("engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = g.Bind("tcp://127.0.0.1:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, err := g.Port(); err != nil || port == 0 {
+		t.Errorf("expected a port, got %d and %v", port, err)
+	}
+
+	g.Close()
+	if err := g.Publish("key", "value", 0); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if _, ok := <-g.Tuples(); ok {
+		t.Error("expected the tuples channel to be closed")
+	}
+}
@@ -0,0 +1,179 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// Elect struct
+// Message used for leader election in a group
+type Elect struct {
+	routingID    []byte
+	version      byte
+	sequence     uint16
+	Group        string
+	ChallengerID string
+}
+
+// NewElect creates new Elect message.
+func NewElect() *Elect {
+	elect := &Elect{}
+	return elect
+}
+
+// String returns print friendly name.
+func (e *Elect) String() string {
+	str := "ZRE_MSG_ELECT:\n"
+	str += fmt.Sprintf("    version = %v\n", e.version)
+	str += fmt.Sprintf("    sequence = %v\n", e.sequence)
+	str += fmt.Sprintf("    Group = %v\n", e.Group)
+	str += fmt.Sprintf("    ChallengerID = %v\n", e.ChallengerID)
+	return str
+}
+
+// Marshal serializes the message.
+func (e *Elect) Marshal() ([]byte, error) {
+	// Calculate size of serialized data
+	bufferSize := 2 + 1 // Signature and message ID
+
+	// version is a 1-byte integer
+	bufferSize++
+
+	// sequence is a 2-byte integer
+	bufferSize += 2
+
+	// Group is a string with 1-byte length
+	bufferSize++ // Size is one byte
+	bufferSize += len(e.Group)
+
+	// ChallengerID is a string with 1-byte length
+	bufferSize++ // Size is one byte
+	bufferSize += len(e.ChallengerID)
+
+	// Now serialize the message
+	tmpBuf := make([]byte, bufferSize)
+	tmpBuf = tmpBuf[:0]
+	buffer := bytes.NewBuffer(tmpBuf)
+	binary.Write(buffer, binary.BigEndian, Signature)
+	binary.Write(buffer, binary.BigEndian, ElectID)
+
+	// version
+	value, _ := strconv.ParseUint("2", 10, 1*8)
+	binary.Write(buffer, binary.BigEndian, byte(value))
+
+	// sequence
+	binary.Write(buffer, binary.BigEndian, e.sequence)
+
+	// Group
+	putString(buffer, e.Group)
+
+	// ChallengerID
+	putString(buffer, e.ChallengerID)
+
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal unmarshals the message.
+func (e *Elect) Unmarshal(frames ...[]byte) error {
+	if frames == nil {
+		return errors.New("Can't unmarshal empty message")
+	}
+
+	frame := frames[0]
+	frames = frames[1:]
+
+	buffer := bytes.NewBuffer(frame)
+
+	// Get and check protocol signature
+	var signature uint16
+	binary.Read(buffer, binary.BigEndian, &signature)
+	if signature != Signature {
+		return fmt.Errorf("invalid signature %X != %X", Signature, signature)
+	}
+
+	// Get message id and parse per message type
+	var id uint8
+	binary.Read(buffer, binary.BigEndian, &id)
+	if id != ElectID {
+		return errors.New("malformed Elect message")
+	}
+	// version
+	binary.Read(buffer, binary.BigEndian, &e.version)
+	if e.version != 2 {
+		return errors.New("malformed version message")
+	}
+	// sequence
+	binary.Read(buffer, binary.BigEndian, &e.sequence)
+	// Group
+	e.Group = getString(buffer)
+	// ChallengerID
+	e.ChallengerID = getString(buffer)
+
+	return nil
+}
+
+// Send sends marshaled data through 0mq socket.
+func (e *Elect) Send(socket *zmq.Socket) (err error) {
+	frame, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+
+	socType, err := socket.GetType()
+	if err != nil {
+		return err
+	}
+
+	// If we're sending to a ROUTER, we send the routingID first
+	if socType == zmq.ROUTER {
+		_, err = socket.SendBytes(e.routingID, zmq.SNDMORE)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Now send the data frame
+	_, err = socket.SendBytes(frame, 0)
+	if err != nil {
+		return err
+	}
+
+	return err
+}
+
+// RoutingID returns the routingID for this message, routingID should be set
+// whenever talking to a ROUTER.
+func (e *Elect) RoutingID() []byte {
+	return e.routingID
+}
+
+// SetRoutingID sets the routingID for this message, routingID should be set
+// whenever talking to a ROUTER.
+func (e *Elect) SetRoutingID(routingID []byte) {
+	e.routingID = routingID
+}
+
+// SetVersion sets the version.
+func (e *Elect) SetVersion(version byte) {
+	e.version = version
+}
+
+// Version returns the version.
+func (e *Elect) Version() byte {
+	return e.version
+}
+
+// SetSequence sets the sequence.
+func (e *Elect) SetSequence(sequence uint16) {
+	e.sequence = sequence
+}
+
+// Sequence returns the sequence.
+func (e *Elect) Sequence() uint16 {
+	return e.sequence
+}
@@ -5,6 +5,10 @@
 // The correct places for commits are:
 //  - The XML model used for this code generation: zre_msg.xml
 //  - The code generation script that built this file: zproto_codec_go
+//
+// The one exception is the content of WHISPER and SHOUT, which is made of
+// all the remaining frames of the message and kept by hand in whisper.go,
+// shout.go and Clone, see README.zre_msg.
 package msg
 
 import (
@@ -32,6 +36,8 @@ const (
 	LeaveID   uint8 = 5
 	PingID    uint8 = 6
 	PingOkID  uint8 = 7
+	ElectID   uint8 = 8
+	LeaderID  uint8 = 9
 )
 
 // Transit is a codec interface
@@ -83,6 +89,10 @@ func Unmarshal(frames ...[]byte) (t Transit, err error) {
 		t = NewPing()
 	case PingOkID:
 		t = NewPingOk()
+	case ElectID:
+		t = NewElect()
+	case LeaderID:
+		t = NewLeader()
 	}
 	err = t.Unmarshal(frames...)
 
@@ -162,7 +172,9 @@ func Clone(t Transit) Transit {
 		cloned.SetRoutingID(routingID)
 		cloned.version = msg.version
 		cloned.sequence = msg.sequence
-		cloned.Content = append(cloned.Content, msg.Content...)
+		for _, frame := range msg.Content {
+			cloned.Content = append(cloned.Content, append([]byte(nil), frame...))
+		}
 		return cloned
 
 	case *Shout:
@@ -173,7 +185,9 @@ func Clone(t Transit) Transit {
 		cloned.version = msg.version
 		cloned.sequence = msg.sequence
 		cloned.Group = msg.Group
-		cloned.Content = append(cloned.Content, msg.Content...)
+		for _, frame := range msg.Content {
+			cloned.Content = append(cloned.Content, append([]byte(nil), frame...))
+		}
 		return cloned
 
 	case *Join:
@@ -215,6 +229,28 @@ func Clone(t Transit) Transit {
 		cloned.version = msg.version
 		cloned.sequence = msg.sequence
 		return cloned
+
+	case *Elect:
+		cloned := NewElect()
+		routingID := make([]byte, len(msg.RoutingID()))
+		copy(routingID, msg.RoutingID())
+		cloned.SetRoutingID(routingID)
+		cloned.version = msg.version
+		cloned.sequence = msg.sequence
+		cloned.Group = msg.Group
+		cloned.ChallengerID = msg.ChallengerID
+		return cloned
+
+	case *Leader:
+		cloned := NewLeader()
+		routingID := make([]byte, len(msg.RoutingID()))
+		copy(routingID, msg.RoutingID())
+		cloned.SetRoutingID(routingID)
+		cloned.version = msg.version
+		cloned.sequence = msg.sequence
+		cloned.Group = msg.Group
+		cloned.LeaderID = msg.LeaderID
+		return cloned
 	}
 
 	return nil
@@ -17,7 +17,7 @@ type Shout struct {
 	version   byte
 	sequence  uint16
 	Group     string
-	Content   []byte
+	Content   [][]byte
 }
 
 // NewShout creates new Shout message.
@@ -104,10 +104,8 @@ func (s *Shout) Unmarshal(frames ...[]byte) error {
 	binary.Read(buffer, binary.BigEndian, &s.sequence)
 	// Group
 	s.Group = getString(buffer)
-	// Content
-	if 0 <= len(frames)-1 {
-		s.Content = frames[0]
-	}
+	// Content, all the remaining frames
+	s.Content = frames
 
 	return nil
 }
@@ -133,12 +131,25 @@ func (s *Shout) Send(socket *zmq.Socket) (err error) {
 	}
 
 	// Now send the data frame
-	_, err = socket.SendBytes(frame, zmq.SNDMORE)
+	more := zmq.SNDMORE
+	if len(s.Content) == 0 {
+		more = 0
+	}
+	_, err = socket.SendBytes(frame, more)
 	if err != nil {
 		return err
 	}
 	// Now send any frame fields, in order
-	_, err = socket.SendBytes(s.Content, 0)
+	for i, content := range s.Content {
+		more = zmq.SNDMORE
+		if i == len(s.Content)-1 {
+			more = 0
+		}
+		_, err = socket.SendBytes(content, more)
+		if err != nil {
+			return err
+		}
+	}
 
 	return err
 }
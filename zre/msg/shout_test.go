@@ -43,7 +43,7 @@ func TestShout(t *testing.T) {
 	shout := NewShout()
 	shout.sequence = 123
 	shout.Group = "Life is short but Now lasts for ever"
-	shout.Content = []byte("Captcha Diem")
+	shout.Content = [][]byte{[]byte("Captcha Diem"), []byte("Carpe Diem")}
 
 	err = shout.Send(output)
 	if err != nil {
@@ -66,8 +66,14 @@ func TestShout(t *testing.T) {
 		t.Fatalf("expected %s, got %s", "Life is short but Now lasts for ever", tr.Group)
 	}
 	// Tests msg
-	if string(tr.Content) != "Captcha Diem" {
-		t.Fatalf("expected %s, got %s", "Captcha Diem", tr.Content)
+	if len(tr.Content) != 2 {
+		t.Fatalf("expected %d frames, got %d", 2, len(tr.Content))
+	}
+	if string(tr.Content[0]) != "Captcha Diem" {
+		t.Fatalf("expected %s, got %s", "Captcha Diem", tr.Content[0])
+	}
+	if string(tr.Content[1]) != "Carpe Diem" {
+		t.Fatalf("expected %s, got %s", "Carpe Diem", tr.Content[1])
 	}
 	err = tr.Send(input)
 	if err != nil {
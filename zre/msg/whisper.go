@@ -16,7 +16,7 @@ type Whisper struct {
 	routingID []byte
 	version   byte
 	sequence  uint16
-	Content   []byte
+	Content   [][]byte
 }
 
 // NewWhisper creates new Whisper message.
@@ -93,10 +93,8 @@ func (w *Whisper) Unmarshal(frames ...[]byte) error {
 	}
 	// sequence
 	binary.Read(buffer, binary.BigEndian, &w.sequence)
-	// Content
-	if 0 <= len(frames)-1 {
-		w.Content = frames[0]
-	}
+	// Content, all the remaining frames
+	w.Content = frames
 
 	return nil
 }
@@ -122,12 +120,25 @@ func (w *Whisper) Send(socket *zmq.Socket) (err error) {
 	}
 
 	// Now send the data frame
-	_, err = socket.SendBytes(frame, zmq.SNDMORE)
+	more := zmq.SNDMORE
+	if len(w.Content) == 0 {
+		more = 0
+	}
+	_, err = socket.SendBytes(frame, more)
 	if err != nil {
 		return err
 	}
 	// Now send any frame fields, in order
-	_, err = socket.SendBytes(w.Content, 0)
+	for i, content := range w.Content {
+		more = zmq.SNDMORE
+		if i == len(w.Content)-1 {
+			more = 0
+		}
+		_, err = socket.SendBytes(content, more)
+		if err != nil {
+			return err
+		}
+	}
 
 	return err
 }
@@ -42,7 +42,7 @@ func TestWhisper(t *testing.T) {
 	// Create a Whisper message and send it through the wire
 	whisper := NewWhisper()
 	whisper.sequence = 123
-	whisper.Content = []byte("Captcha Diem")
+	whisper.Content = [][]byte{[]byte("Captcha Diem"), []byte("Carpe Diem")}
 
 	err = whisper.Send(output)
 	if err != nil {
@@ -61,8 +61,14 @@ func TestWhisper(t *testing.T) {
 		t.Fatalf("expected %d, got %d", 123, tr.sequence)
 	}
 	// Tests msg
-	if string(tr.Content) != "Captcha Diem" {
-		t.Fatalf("expected %s, got %s", "Captcha Diem", tr.Content)
+	if len(tr.Content) != 2 {
+		t.Fatalf("expected %d frames, got %d", 2, len(tr.Content))
+	}
+	if string(tr.Content[0]) != "Captcha Diem" {
+		t.Fatalf("expected %s, got %s", "Captcha Diem", tr.Content[0])
+	}
+	if string(tr.Content[1]) != "Carpe Diem" {
+		t.Fatalf("expected %s, got %s", "Carpe Diem", tr.Content[1])
 	}
 	err = tr.Send(input)
 	if err != nil {